@@ -0,0 +1,114 @@
+package airsensor
+
+import "time"
+
+// Stats summarizes the valid readings taken within a window.
+type Stats struct {
+	Min    int16
+	Max    int16
+	Avg    float64
+	Count  int
+	Window time.Duration
+}
+
+// Aggregator keeps the most recent readings in a ring buffer and computes
+// statistics over the valid ones. Like EMA, it is not safe for concurrent
+// use.
+type Aggregator struct {
+	ring []Reading
+	next int
+	full bool
+}
+
+// NewAggregator returns an Aggregator keeping up to size readings.
+func NewAggregator(size int) *Aggregator {
+	return &Aggregator{ring: make([]Reading, size)}
+}
+
+// Add records r, replacing the oldest reading if the buffer is full.
+// Invalid readings only count towards ValidRatio.
+func (a *Aggregator) Add(r *Reading) {
+	if len(a.ring) == 0 {
+		return
+	}
+	a.ring[a.next] = *r
+	a.next++
+	if a.next == len(a.ring) {
+		a.next, a.full = 0, true
+	}
+}
+
+// Readings returns the kept valid readings taken between from and to,
+// oldest first. As in Stats, a reading is placed on the monotonic clock,
+// its age at now, as returned by time.Now, before now, so a step of the
+// wall clock doesn't move it in or out of the range.
+func (a *Aggregator) Readings(from, to, now time.Time) []Reading {
+	var rs []Reading
+	start := 0
+	if a.full {
+		start = a.next
+	}
+	n := a.next
+	if a.full {
+		n = len(a.ring)
+	}
+	for i := 0; i < n; i++ {
+		r := a.ring[(start+i)%len(a.ring)]
+		at := now.Add(-r.ageAt(now))
+		if r.Valid && !at.Before(from) && !at.After(to) {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// Stats returns the statistics of the valid readings taken within window
+// before now, as returned by time.Now. Count is 0 if there are none. A
+// window longer than the buffer covers only the readings still kept.
+// Readings are placed in the window by their Monotonic time, so a step of
+// the wall clock doesn't move them in or out.
+func (a *Aggregator) Stats(window time.Duration, now time.Time) Stats {
+	st := Stats{Window: window}
+	n := a.next
+	if a.full {
+		n = len(a.ring)
+	}
+	var sum float64
+	for i := 0; i < n; i++ {
+		r := &a.ring[i]
+		if age := r.ageAt(now); !r.Valid || age < 0 || age > window {
+			continue
+		}
+		if st.Count == 0 || r.VOC < st.Min {
+			st.Min = r.VOC
+		}
+		if st.Count == 0 || r.VOC > st.Max {
+			st.Max = r.VOC
+		}
+		sum += float64(r.VOC)
+		st.Count++
+	}
+	if st.Count > 0 {
+		st.Avg = sum / float64(st.Count)
+	}
+	return st
+}
+
+// ValidRatio returns the fraction of valid readings among the n kept
+// ones. It is 0 if there are none.
+func (a *Aggregator) ValidRatio() (ratio float64, n int) {
+	n = a.next
+	if a.full {
+		n = len(a.ring)
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	valid := 0
+	for i := 0; i < n; i++ {
+		if a.ring[i].Valid {
+			valid++
+		}
+	}
+	return float64(valid) / float64(n), n
+}
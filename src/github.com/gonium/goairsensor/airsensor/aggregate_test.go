@@ -0,0 +1,125 @@
+package airsensor
+
+import (
+	"testing"
+	"time"
+)
+
+// taken returns a reading of voc taken age before now.
+func taken(voc int16, age time.Duration, now time.Time) *Reading {
+	ts := now.Add(-age)
+	return &Reading{VOC: voc, Valid: voc >= MinVOC && voc <= MaxVOC, Timestamp: ts, Monotonic: monotonic(ts)}
+}
+
+func TestAggregatorStats(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		size   int
+		add    []*Reading
+		window time.Duration
+		want   Stats
+	}{
+		{
+			name:   "empty",
+			size:   4,
+			window: time.Minute,
+			want:   Stats{Window: time.Minute},
+		},
+		{
+			name:   "only invalid readings",
+			size:   4,
+			add:    []*Reading{taken(100, time.Second, now), taken(3000, 2*time.Second, now)},
+			window: time.Minute,
+			// Count stays 0, Min, Max and Avg are left zero.
+			want: Stats{Window: time.Minute},
+		},
+		{
+			name:   "min, max and mean",
+			size:   4,
+			add:    []*Reading{taken(600, 3*time.Second, now), taken(900, 2*time.Second, now), taken(750, time.Second, now)},
+			window: time.Minute,
+			want:   Stats{Min: 600, Max: 900, Avg: 750, Count: 3, Window: time.Minute},
+		},
+		{
+			name:   "invalid readings left out",
+			size:   4,
+			add:    []*Reading{taken(600, 3*time.Second, now), taken(0, 2*time.Second, now), taken(800, time.Second, now)},
+			window: time.Minute,
+			want:   Stats{Min: 600, Max: 800, Avg: 700, Count: 2, Window: time.Minute},
+		},
+		{
+			name:   "readings older than the window expire",
+			size:   4,
+			add:    []*Reading{taken(1500, 10*time.Minute, now), taken(600, 2*time.Minute, now), taken(700, time.Minute, now)},
+			window: 5 * time.Minute,
+			want:   Stats{Min: 600, Max: 700, Avg: 650, Count: 2, Window: 5 * time.Minute},
+		},
+		{
+			name: "ring wraps around",
+			size: 3,
+			add: []*Reading{
+				taken(1900, 5*time.Second, now), taken(1800, 4*time.Second, now),
+				taken(600, 3*time.Second, now), taken(700, 2*time.Second, now), taken(800, time.Second, now),
+			},
+			window: time.Minute,
+			want:   Stats{Min: 600, Max: 800, Avg: 700, Count: 3, Window: time.Minute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAggregator(tt.size)
+			for _, r := range tt.add {
+				a.Add(r)
+			}
+			if got := a.Stats(tt.window, now); got != tt.want {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregatorReadings(t *testing.T) {
+	now := time.Now()
+	a := NewAggregator(3)
+	for i, voc := range []int16{1900, 600, 0, 700, 800} {
+		a.Add(taken(voc, time.Duration(5-i)*time.Minute, now))
+	}
+	// The ring keeps 0, 700 and 800; the invalid 0 is left out.
+	rs := a.Readings(now.Add(-time.Hour), now, now)
+	if len(rs) != 2 || rs[0].VOC != 700 || rs[1].VOC != 800 {
+		t.Fatalf("Readings() = %v, want 700 and 800, oldest first", rs)
+	}
+	rs = a.Readings(now.Add(-90*time.Second), now, now)
+	if len(rs) != 1 || rs[0].VOC != 800 {
+		t.Errorf("Readings() of the last 90s = %v, want 800", rs)
+	}
+
+	// Readings are placed by their monotonic time, like in Stats, even
+	// if the wall clock was stepped since.
+	stepped := taken(900, 30*time.Second, now)
+	stepped.Timestamp = stepped.Timestamp.Add(-2 * time.Hour)
+	a.Add(stepped)
+	rs = a.Readings(now.Add(-90*time.Second), now, now)
+	if len(rs) != 2 || rs[0].VOC != 800 || rs[1].VOC != 900 {
+		t.Errorf("Readings() of the last 90s after a clock step = %v, want 800 and 900", rs)
+	}
+	if st := a.Stats(90*time.Second, now); st.Count != 2 {
+		t.Errorf("Stats() of the last 90s after a clock step = %+v, want the same 2 readings", st)
+	}
+}
+
+func TestAggregatorValidRatio(t *testing.T) {
+	now := time.Now()
+	a := NewAggregator(4)
+	if ratio, n := a.ValidRatio(); ratio != 0 || n != 0 {
+		t.Errorf("ValidRatio() of an empty aggregator = %g, %d, want 0, 0", ratio, n)
+	}
+	for _, voc := range []int16{600, 0, 700, 0, 800} {
+		a.Add(taken(voc, time.Second, now))
+	}
+	// The ring keeps 0, 700, 0 and 800.
+	if ratio, n := a.ValidRatio(); ratio != 0.5 || n != 4 {
+		t.Errorf("ValidRatio() = %g, %d, want 0.5, 4", ratio, n)
+	}
+}
@@ -0,0 +1,51 @@
+package airsensor
+
+import "math"
+
+// minAnomalyStdDev is the smallest standard deviation Anomaly assumes, the
+// resolution of the sensor in ppm, so a change of a flat signal by a
+// single ppm isn't taken for a spike.
+const minAnomalyStdDev = 1
+
+// Anomaly flags sudden spikes: values deviating by more than Sigma
+// standard deviations from the mean of the last Window values. A Sigma of
+// 0 disables it. Like EMA, it is not safe for concurrent use.
+type Anomaly struct {
+	Sigma  float64
+	Window int
+
+	ring []float64
+	next int
+	full bool
+}
+
+// Update reports whether v is anomalous compared to the values before it
+// and then adds it to the window, so a lasting change becomes the new
+// normal. Nothing is anomalous until Window values have been seen.
+func (a *Anomaly) Update(v float64) bool {
+	if a.Sigma <= 0 || a.Window < 2 {
+		return false
+	}
+	if len(a.ring) != a.Window {
+		a.ring, a.next, a.full = make([]float64, a.Window), 0, false
+	}
+	anomalous := false
+	if a.full {
+		var sum, sq float64
+		for _, x := range a.ring {
+			sum += x
+		}
+		mean := sum / float64(len(a.ring))
+		for _, x := range a.ring {
+			sq += (x - mean) * (x - mean)
+		}
+		sd := math.Max(math.Sqrt(sq/float64(len(a.ring))), minAnomalyStdDev)
+		anomalous = math.Abs(v-mean) > a.Sigma*sd
+	}
+	a.ring[a.next] = v
+	a.next++
+	if a.next == len(a.ring) {
+		a.next, a.full = 0, true
+	}
+	return anomalous
+}
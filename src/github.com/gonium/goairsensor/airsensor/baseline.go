@@ -0,0 +1,49 @@
+package airsensor
+
+import (
+	"math"
+	"time"
+)
+
+// Baseline tracks the clean-air level of a drifting sensor: the minimum of
+// the values seen, decaying towards newer values so it follows the drift.
+// A value below the baseline becomes the new baseline right away; above
+// it, the baseline rises towards the value by half the distance every
+// HalfLife, so a few hours of bad air barely lift it. A HalfLife of 0
+// disables it. Like EMA, it is not safe for concurrent use.
+type Baseline struct {
+	HalfLife time.Duration
+
+	value float64
+	at    time.Time
+	init  bool
+}
+
+// Update adds v seen at t and returns the new baseline. Disabled, it
+// returns v.
+func (b *Baseline) Update(v float64, t time.Time) float64 {
+	if b.HalfLife <= 0 {
+		return v
+	}
+	if !b.init || v <= b.value {
+		b.value, b.at, b.init = v, t, true
+		return v
+	}
+	if dt := t.Sub(b.at); dt > 0 {
+		b.value += (v - b.value) * (1 - math.Exp2(-float64(dt)/float64(b.HalfLife)))
+	}
+	b.at = t
+	return b.value
+}
+
+// Value returns the current baseline, when it was last updated and
+// whether there is one yet.
+func (b *Baseline) Value() (float64, time.Time, bool) {
+	return b.value, b.at, b.init
+}
+
+// Set makes v, last updated at t, the current baseline, e.g. to resume
+// from a saved state.
+func (b *Baseline) Set(v float64, t time.Time) {
+	b.value, b.at, b.init = v, t, true
+}
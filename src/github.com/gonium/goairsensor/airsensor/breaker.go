@@ -0,0 +1,118 @@
+package airsensor
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BreakerState is the state of a Breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets reads through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects reads until the cooldown has passed.
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial read through.
+	BreakerHalfOpen
+)
+
+func (st BreakerState) String() string {
+	names := [...]string{"closed", "open", "half_open"}
+	if st < 0 || int(st) >= len(names) {
+		return fmt.Sprintf("BreakerState(%d)", int(st))
+	}
+	return names[st]
+}
+
+// Breaker is a SensorReader that stops reading a failing sensor for a
+// while instead of hammering it. After Threshold consecutive failed reads
+// it opens and fails reads right away with ErrBreakerOpen. Once Cooldown
+// has passed it half-opens and lets one read through: if that succeeds it
+// closes again, otherwise it opens for another Cooldown. Out-of-range
+// values aren't failures, see Reading.Err.
+type Breaker struct {
+	s         SensorReader
+	threshold int
+	cooldown  time.Duration
+
+	// OnStateChange, if set, is called with the new state whenever it
+	// changes. Set it before the first read.
+	OnStateChange func(BreakerState)
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// state is read without mu, so State doesn't wait for a read in
+	// flight.
+	state atomic.Int32
+}
+
+// NewBreaker wraps s in a Breaker opening after threshold consecutive
+// failures for cooldown.
+func NewBreaker(s SensorReader, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{s: s, threshold: threshold, cooldown: cooldown}
+}
+
+// setState changes the state, telling OnStateChange.
+func (b *Breaker) setState(st BreakerState) {
+	if BreakerState(b.state.Swap(int32(st))) == st {
+		return
+	}
+	if b.OnStateChange != nil {
+		b.OnStateChange(st)
+	}
+}
+
+// ReadVOC reads from the wrapped sensor unless the breaker is open.
+func (b *Breaker) ReadVOC() (*Reading, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.State() == BreakerOpen {
+		wait := b.cooldown - time.Since(b.openedAt)
+		if wait > 0 {
+			return nil, fmt.Errorf("%w after %d failed reads, retrying in %s", ErrBreakerOpen, b.failures, wait.Round(time.Millisecond))
+		}
+		b.setState(BreakerHalfOpen)
+	}
+
+	r, err := b.s.ReadVOC()
+	if err == nil {
+		if b.State() == BreakerHalfOpen {
+			slog.Info("Circuit breaker closed, sensor recovered", "failed_reads", b.failures)
+		}
+		b.failures = 0
+		b.setState(BreakerClosed)
+		return r, nil
+	}
+	b.failures++
+	if b.State() == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.State() == BreakerClosed {
+			slog.Warn("Circuit breaker opened", "failed_reads", b.failures, "cooldown", b.cooldown, "err", err)
+		}
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+	return r, err
+}
+
+// State returns the current state. It doesn't wait for a read in flight.
+func (b *Breaker) State() BreakerState {
+	return BreakerState(b.state.Load())
+}
+
+// SetDebug changes the libusb debug level of the wrapped sensor, if it
+// supports that.
+func (b *Breaker) SetDebug(level int) {
+	if d, ok := b.s.(interface{ SetDebug(int) }); ok {
+		d.SetDebug(level)
+	}
+}
+
+// Close closes the wrapped sensor.
+func (b *Breaker) Close() error {
+	return b.s.Close()
+}
@@ -0,0 +1,52 @@
+package airsensor
+
+import (
+	"fmt"
+	"github.com/google/gousb"
+	"strings"
+)
+
+// Standard control request fetching a descriptor from the device.
+const (
+	requestTypeGetDescriptor = 0x80 // device-to-host, standard, device
+	requestGetDescriptor     = 0x06
+	deviceDescriptorSize     = 18
+)
+
+// Offsets of the string descriptor indices in the device descriptor.
+const (
+	offsetManufacturer = 14
+	offsetProduct      = 15
+	offsetSerialNumber = 16
+)
+
+// deviceDescriptor reads the raw device descriptor. gousb's DeviceDesc
+// doesn't carry the string descriptor indices, so they have to be taken
+// from the descriptor itself.
+func deviceDescriptor(dev *gousb.Device) ([]byte, error) {
+	buf := make([]byte, deviceDescriptorSize)
+	num, err := dev.Control(requestTypeGetDescriptor, requestGetDescriptor,
+		uint16(gousb.DescriptorTypeDevice)<<8, 0, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device descriptor of %s: %v", dev, err)
+	}
+	if num < deviceDescriptorSize {
+		return nil, fmt.Errorf("short device descriptor of %s: got %d bytes", dev, num)
+	}
+	return buf, nil
+}
+
+// stringDescriptor reads the string descriptor whose index is stored at
+// offset in the device descriptor desc. A missing descriptor yields "";
+// padding some devices fill their strings up with is trimmed.
+func stringDescriptor(dev *gousb.Device, desc []byte, offset int) (string, error) {
+	idx := int(desc[offset])
+	if idx == 0 {
+		return "", nil
+	}
+	s, err := dev.GetStringDescriptor(idx)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00")), nil
+}
@@ -0,0 +1,193 @@
+package airsensor
+
+import (
+	"fmt"
+	"github.com/google/gousb"
+	"strconv"
+	"strings"
+)
+
+// A DeviceID is the USB vendor and product ID of a device.
+type DeviceID struct {
+	Vendor  gousb.ID
+	Product gousb.ID
+}
+
+// String returns the ID as vvvv:pppp in hex, as lsusb prints it.
+func (id DeviceID) String() string {
+	return fmt.Sprintf("%s:%s", id.Vendor, id.Product)
+}
+
+// DeviceIDs is a set of accepted device IDs.
+type DeviceIDs []DeviceID
+
+// String returns the IDs separated by commas.
+func (ids DeviceIDs) String() string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = id.String()
+	}
+	return strings.Join(s, ",")
+}
+
+func (ids DeviceIDs) match(desc *gousb.DeviceDesc) bool {
+	for _, id := range ids {
+		if desc.Vendor == id.Vendor && desc.Product == id.Product {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDeviceIDs parses a comma-separated list of vvvv:pppp IDs in hex,
+// e.g. "03eb:2013,03eb:2011".
+func ParseDeviceIDs(s string) (DeviceIDs, error) {
+	var ids DeviceIDs
+	for _, f := range strings.Split(s, ",") {
+		vid, pid, ok := strings.Cut(strings.TrimSpace(f), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid device ID %q, must be vvvv:pppp", f)
+		}
+		v, err := strconv.ParseUint(vid, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vendor ID in %q: %w", f, err)
+		}
+		p, err := strconv.ParseUint(pid, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product ID in %q: %w", f, err)
+		}
+		ids = append(ids, DeviceID{Vendor: gousb.ID(v), Product: gousb.ID(p)})
+	}
+	return ids, nil
+}
+
+// SupportedDevices are the devices discovery accepts, by default the
+// iAQ-Stick and its 0x2011 variant. Change it before opening sensors.
+var SupportedDevices = DeviceIDs{
+	{Vendor: VendorID, Product: ProductID},
+	{Vendor: VendorID, Product: ProductID2011},
+}
+
+// SensorInfo describes a connected iAQ-Stick.
+type SensorInfo struct {
+	Bus     int
+	Address int
+	Serial  string
+	// Vendor and Product are the IDs the stick matched in
+	// SupportedDevices.
+	Vendor  gousb.ID
+	Product gousb.ID
+	// Firmware is the device release number (bcdDevice) of the stick,
+	// e.g. "1.00", see Sensor.FirmwareVersion.
+	Firmware string
+	// Manufacturer and ProductName are the iManufacturer and iProduct
+	// strings of the stick, e.g. "AppliedSensor" and "iAQ Stick". They
+	// tell a genuine stick from another device sharing its IDs; empty if
+	// the device has none or they can't be read.
+	Manufacturer string
+	ProductName  string
+}
+
+// String returns a human-readable description of the sensor.
+func (i SensorInfo) String() string {
+	return fmt.Sprintf("bus=%d,addr=%d,serial=%q,product=%s", i.Bus, i.Address, i.Serial, i.Product)
+}
+
+// Device returns the vendor and product ID the stick matched.
+func (i SensorInfo) Device() DeviceID {
+	return DeviceID{Vendor: i.Vendor, Product: i.Product}
+}
+
+// ID identifies the sensor: its serial number, or its bus:address pair if
+// it has none.
+func (i SensorInfo) ID() string {
+	if i.Serial == "" {
+		return fmt.Sprintf("%d:%d", i.Bus, i.Address)
+	}
+	return i.Serial
+}
+
+// A Selector reports whether Open should use the described sensor.
+type Selector func(info SensorInfo) bool
+
+// ByBusAddress selects the sensor at the given bus and address, as listed
+// by Discover.
+func ByBusAddress(bus, address int) Selector {
+	return func(info SensorInfo) bool {
+		return info.Bus == bus && info.Address == address
+	}
+}
+
+// openDevices opens all connected SupportedDevices. As with gousb's
+// OpenDevices, every returned device must be closed, even if an error is
+// returned as well.
+func openDevices(ctx *gousb.Context) ([]*gousb.Device, error) {
+	return ctx.OpenDevices(SupportedDevices.match)
+}
+
+// infoOf describes an opened device. Unreadable strings, like the serial
+// number, are left empty.
+func infoOf(dev *gousb.Device) SensorInfo {
+	info := SensorInfo{
+		Bus:      dev.Desc.Bus,
+		Address:  dev.Desc.Address,
+		Vendor:   dev.Desc.Vendor,
+		Product:  dev.Desc.Product,
+		Firmware: dev.Desc.Device.String(),
+	}
+	desc, err := deviceDescriptor(dev)
+	if err != nil {
+		return info
+	}
+	info.Serial, _ = stringDescriptor(dev, desc, offsetSerialNumber)
+	info.Manufacturer, _ = stringDescriptor(dev, desc, offsetManufacturer)
+	info.ProductName, _ = stringDescriptor(dev, desc, offsetProduct)
+	return info
+}
+
+// BySerial selects the sensor with the given USB serial number. Unlike the
+// bus and address, it stays the same across reboots and ports.
+func BySerial(serial string) Selector {
+	return func(info SensorInfo) bool {
+		return info.Serial == serial
+	}
+}
+
+// ByID selects the sensor with the given ID, see SensorInfo.ID.
+func ByID(id string) Selector {
+	return func(info SensorInfo) bool {
+		return info.ID() == id
+	}
+}
+
+// Discover lists all connected iAQ-Sticks. If enumeration fails for some
+// devices, the ones found so far are returned along with the error.
+func Discover() ([]SensorInfo, error) {
+	ctx := gousb.NewContext()
+	defer ctx.Close()
+
+	devs, err := openDevices(ctx)
+	var infos []SensorInfo
+	for _, dev := range devs {
+		infos = append(infos, infoOf(dev))
+		dev.Close()
+	}
+	return infos, err
+}
+
+// Descriptors returns the device descriptors of all connected iAQ-Sticks,
+// including their configs, interfaces and endpoints. The sticks are not
+// opened, so this works while they are in use.
+func Descriptors() ([]*gousb.DeviceDesc, error) {
+	ctx := gousb.NewContext()
+	defer ctx.Close()
+
+	var descs []*gousb.DeviceDesc
+	_, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if SupportedDevices.match(desc) {
+			descs = append(descs, desc)
+		}
+		return false
+	})
+	return descs, err
+}
@@ -0,0 +1,31 @@
+package airsensor
+
+// EMA is an exponential moving average. Each update moves the average by
+// Alpha towards the new value; Alpha must lie in (0, 1]. An Alpha of 0
+// disables smoothing, the average then always equals the last value.
+type EMA struct {
+	Alpha float64
+
+	value float64
+	init  bool
+}
+
+// Update adds v to the average and returns the new average.
+func (e *EMA) Update(v float64) float64 {
+	if !e.init || e.Alpha <= 0 {
+		e.value, e.init = v, true
+		return v
+	}
+	e.value += e.Alpha * (v - e.value)
+	return e.value
+}
+
+// Value returns the current average and whether there is one yet.
+func (e *EMA) Value() (float64, bool) {
+	return e.value, e.init
+}
+
+// Set makes v the current average, e.g. to resume from a saved state.
+func (e *EMA) Set(v float64) {
+	e.value, e.init = v, true
+}
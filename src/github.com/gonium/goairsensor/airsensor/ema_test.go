@@ -0,0 +1,47 @@
+package airsensor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEMA(t *testing.T) {
+	tests := []struct {
+		name  string
+		alpha float64
+		in    []float64
+		want  []float64
+	}{
+		{"seeded by the first value", 0.5, []float64{600}, []float64{600}},
+		{"half way", 0.5, []float64{600, 700, 800, 600}, []float64{600, 650, 725, 662.5}},
+		{"slow", 0.1, []float64{500, 600, 600, 600}, []float64{500, 510, 519, 527.1}},
+		{"alpha 1 follows the values", 1, []float64{500, 900, 700}, []float64{500, 900, 700}},
+		{"disabled", 0, []float64{500, 900, 700}, []float64{500, 900, 700}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := EMA{Alpha: tt.alpha}
+			if _, ok := e.Value(); ok {
+				t.Fatal("Value() reports an average before the first update")
+			}
+			for i, v := range tt.in {
+				got := e.Update(v)
+				if math.Abs(got-tt.want[i]) > 1e-9 {
+					t.Fatalf("step %d: Update(%g) = %g, want %g", i+1, v, got, tt.want[i])
+				}
+			}
+			last := tt.want[len(tt.want)-1]
+			if v, ok := e.Value(); !ok || math.Abs(v-last) > 1e-9 {
+				t.Errorf("Value() = %g, %t, want %g, true", v, ok, last)
+			}
+		})
+	}
+}
+
+func TestEMASet(t *testing.T) {
+	e := EMA{Alpha: 0.5}
+	e.Set(800)
+	if got := e.Update(600); got != 700 {
+		t.Errorf("Update(600) after Set(800) = %g, want 700", got)
+	}
+}
@@ -0,0 +1,85 @@
+package airsensor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/gousb"
+)
+
+// Errors returned by the sensor. They are wrapped with details, use
+// errors.Is to check for them.
+var (
+	// ErrDeviceNotFound means that no matching stick is connected.
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrReadTimeout means that the device didn't answer in time.
+	ErrReadTimeout = errors.New("read timeout")
+	// ErrBadFrame is returned when the device answers with a frame that
+	// isn't a response to the request command, e.g. because a stale
+	// frame was still pending.
+	ErrBadFrame = errors.New("bad response frame")
+	// ErrValueOutOfRange means that the VOC value lies outside of MinVOC
+	// and MaxVOC. ReadVOC doesn't return it, see Reading.Err.
+	ErrValueOutOfRange = errors.New("value out of range")
+	// ErrBelowRange, ErrAboveRange and ErrBogusValue tell apart why a
+	// value is out of range, see Range. Errors wrapping them also wrap
+	// ErrValueOutOfRange.
+	ErrBelowRange = errors.New("value below range")
+	ErrAboveRange = errors.New("value above range")
+	ErrBogusValue = errors.New("bogus value")
+	// ErrBreakerOpen is returned by a Breaker that doesn't let reads
+	// through after too many consecutive failures.
+	ErrBreakerOpen = errors.New("circuit breaker open")
+)
+
+// isTimeout reports whether err is a timed out USB transfer or an expired
+// deadline.
+func isTimeout(err error) bool {
+	return errors.Is(err, gousb.ErrorTimeout) ||
+		errors.Is(err, gousb.TransferTimedOut) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// transferError describes a failed transfer, marking timeouts with
+// ErrReadTimeout.
+func transferError(what string, err error) error {
+	if isTimeout(err) {
+		return fmt.Errorf("%s: %w: %w", what, ErrReadTimeout, err)
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
+
+// contextError marks an expired deadline with ErrReadTimeout.
+func contextError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrReadTimeout, err)
+	}
+	return err
+}
+
+// Reason classifies an error returned by a read, or by Reading.Err, as
+// one of device_not_found, read_timeout, bad_frame, breaker_open,
+// below_range, above_range, bogus_value, value_out_of_range or other, e.g.
+// for a metric label.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrDeviceNotFound):
+		return "device_not_found"
+	case errors.Is(err, ErrReadTimeout):
+		return "read_timeout"
+	case errors.Is(err, ErrBadFrame):
+		return "bad_frame"
+	case errors.Is(err, ErrBreakerOpen):
+		return "breaker_open"
+	case errors.Is(err, ErrBelowRange):
+		return "below_range"
+	case errors.Is(err, ErrAboveRange):
+		return "above_range"
+	case errors.Is(err, ErrBogusValue):
+		return "bogus_value"
+	case errors.Is(err, ErrValueOutOfRange):
+		return "value_out_of_range"
+	default:
+		return "other"
+	}
+}
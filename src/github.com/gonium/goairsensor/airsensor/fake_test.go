@@ -0,0 +1,101 @@
+package airsensor
+
+import (
+	"github.com/google/gousb"
+	"sync"
+	"time"
+)
+
+// fakeEndpoint stands in for the endpoints of a stick in tests of the
+// handshake. It records the frames written to it and answers every read
+// with the next of its canned frames; once they run out, reads time out
+// like those of a stick with nothing pending. A nil frame times out too,
+// so a script can have the flush find nothing before the next response.
+type fakeEndpoint struct {
+	mu        sync.Mutex
+	responses [][]byte
+	writes    [][]byte
+	// err, once set, fails every transfer, see unplug.
+	err error
+
+	// answer, if set, makes the fake act like a stick: every request
+	// written queues the frame it returns, to be read as the response.
+	answer func(req []byte) []byte
+	// delay is how long every transfer takes, to give concurrent
+	// handshakes the chance to interleave.
+	delay time.Duration
+	// interleaved counts requests written while the response to the
+	// previous one was still unread.
+	interleaved int
+}
+
+// newFakeEndpoint returns a fakeEndpoint answering reads with responses
+// in order.
+func newFakeEndpoint(responses ...[]byte) *fakeEndpoint {
+	return &fakeEndpoint{responses: responses}
+}
+
+func (f *fakeEndpoint) Read(buf []byte) (int, error) {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	if len(f.responses) == 0 {
+		return 0, gousb.ErrorTimeout
+	}
+	frame := f.responses[0]
+	f.responses = f.responses[1:]
+	if frame == nil {
+		return 0, gousb.ErrorTimeout
+	}
+	return copy(buf, frame), nil
+}
+
+func (f *fakeEndpoint) Write(buf []byte) (int, error) {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.writes = append(f.writes, append([]byte(nil), buf...))
+	if f.answer != nil {
+		if len(f.responses) > 0 {
+			f.interleaved++
+		}
+		f.responses = append(f.responses, f.answer(buf))
+	}
+	return len(buf), nil
+}
+
+// unplug makes every later transfer fail the way it does once the stick
+// is gone.
+func (f *fakeEndpoint) unplug() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = gousb.ErrorNoDevice
+}
+
+// written returns the frames written so far.
+func (f *fakeEndpoint) written() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writes
+}
+
+// pending returns the number of canned frames not read yet.
+func (f *fakeEndpoint) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.responses)
+}
+
+// newFakeSensor returns a Sensor with the defaults of Open exchanging its
+// frames with ep instead of a stick.
+func newFakeSensor(ep endpoint) *Sensor {
+	s := newSensor(Options{Transport: TransportEndpoints})
+	s.ep = ep
+	return s
+}
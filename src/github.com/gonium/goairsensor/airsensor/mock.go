@@ -0,0 +1,64 @@
+package airsensor
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MockResult is a scripted outcome of a MockSensor read. If Err is set, the
+// read fails with it; otherwise a frame carrying VOC is decoded.
+type MockResult struct {
+	VOC int16
+	Err error
+}
+
+// MockSensor is a SensorReader that replays a scripted sequence of results,
+// for testing code that consumes readings without a stick attached.
+type MockSensor struct {
+	mu      sync.Mutex
+	results []MockResult
+	next    int
+	closed  bool
+}
+
+// NewMockSensor returns a MockSensor replaying results in order.
+func NewMockSensor(results ...MockResult) *MockSensor {
+	return &MockSensor{results: results}
+}
+
+// ReadVOC returns the next scripted result. It fails once the script is
+// exhausted or the mock is closed.
+func (m *MockSensor) ReadVOC() (*Reading, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errors.New("mock sensor: ReadVOC() called after Close")
+	}
+	if m.next >= len(m.results) {
+		return nil, errors.New("mock sensor: script exhausted")
+	}
+	res := m.results[m.next]
+	m.next++
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return parseReading(mockFrame(res.VOC), time.Now(), DefaultParseConfig)
+}
+
+// Close marks the mock as closed.
+func (m *MockSensor) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+// mockFrame builds a response frame carrying voc.
+func mockFrame(voc int16) []byte {
+	frame := make([]byte, FrameSize)
+	copy(frame, responseHeader)
+	binary.LittleEndian.PutUint16(frame[offsetVOC:], uint16(voc))
+	return frame
+}
@@ -0,0 +1,60 @@
+package airsensor
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// How sure the description of a frame field is.
+const (
+	// Known fields are used by the parser and hold on every stick
+	// seen so far.
+	Known = "known"
+	// Experimental fields come from observing the frames of a few
+	// sticks and may be wrong for other firmware revisions.
+	Experimental = "experimental"
+	// Unknown bytes have no known meaning.
+	Unknown = "unknown"
+)
+
+// A FrameField describes a field of a request or response frame.
+type FrameField struct {
+	Offset     int
+	Size       int
+	Meaning    string
+	Confidence string
+}
+
+// RequestLayout describes the default request command, see
+// DefaultRequestCommand. It is derived from the command itself, so it
+// always matches what is sent.
+func RequestLayout() []FrameField {
+	return []FrameField{
+		{0, offsetCommand, fmt.Sprintf("frame header %q, echoed at the start of the response", requestCommand[:offsetCommand]), Known},
+		{offsetCommand, offsetEnd - offsetCommand, fmt.Sprintf("read command %q", requestCommand[offsetCommand:offsetEnd]), Known},
+		{offsetEnd, offsetFiller - offsetEnd, fmt.Sprintf("end of the command %q", requestCommand[offsetEnd:offsetFiller]), Known},
+		{offsetFiller, len(requestCommand) - offsetFiller, fmt.Sprintf("filler %q up to the frame size; no checksum is known", requestCommand[offsetFiller:offsetFiller+1]), Known},
+	}
+}
+
+// ResponseLayout describes the fields of a response frame of FrameSize
+// bytes at the offsets the parser reads them from, with the VOC value
+// where pc says. The temperature some firmware revisions report has no
+// fixed offset, see Sensor.TemperatureOffset.
+func ResponseLayout(pc ParseConfig) []FrameField {
+	order := "little-endian"
+	if pc.byteOrder() == binary.BigEndian {
+		order = "big-endian"
+	}
+	voc := FrameField{pc.VOCOffset, sizeVOC, fmt.Sprintf("VOC concentration, %s int16, ppm CO2-equivalent; valid from %d to %d", order, MinVOC, MaxVOC), Known}
+	if pc.VOCOffset != offsetVOC || order != "little-endian" {
+		voc.Confidence = Experimental
+	}
+	return []FrameField{
+		{0, len(responseHeader), fmt.Sprintf("response header %q, must echo the request header", responseHeader), Known},
+		voc,
+		{offsetResistance, sizeResistance, "raw sensor resistance, little-endian uint32", Experimental},
+		{offsetStatus, sizeStatus, "status flags", Experimental},
+		{offsetUnknown, FrameSize - offsetUnknown, "no known meaning", Unknown},
+	}
+}
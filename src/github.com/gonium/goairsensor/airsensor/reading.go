@@ -0,0 +1,322 @@
+package airsensor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// FrameSize is the size of a single frame sent by the iAQ-Stick. Firmware
+// returning longer frames can be read by setting Sensor.FrameSize.
+//
+// Layout of a response frame as far as it is known:
+//
+//	offset  size  meaning
+//	0       2     response header, echoes "@h" of the request command
+//	2       2     VOC concentration, little-endian int16, ppm CO2-equivalent
+//	4       4     raw sensor resistance, little-endian uint32 (experimental)
+//	8       1     status flags (experimental)
+//	9       7     unknown
+//
+// The experimental fields come from observing frames of a few sticks and
+// may be wrong for other firmware revisions. ResponseLayout describes the
+// same for printing.
+const FrameSize = 16
+
+// requestCommand asks the stick for a reading. It is ASCII text padded to
+// a full frame:
+//
+//	offset  bytes  meaning
+//	0       "@h"   frame header, echoed at the start of the response
+//	2       "*TR"  read command
+//	5       "\n"   end of the command
+//	6       "@"    filler up to FrameSize; no checksum is known
+//
+// RequestLayout describes the same for printing.
+var requestCommand = []byte{
+	0x40, 0x68, // "@h"
+	0x2a, 0x54, 0x52, // "*TR"
+	0x0a, // "\n"
+	0x40, 0x40, 0x40, 0x40, 0x40, 0x40, 0x40, 0x40, 0x40, 0x40,
+}
+
+// DefaultRequestCommand returns a copy of the command asking for a
+// reading, for use as a starting point for Sensor.RequestCommand.
+func DefaultRequestCommand() []byte {
+	return append([]byte(nil), requestCommand...)
+}
+
+// responseHeader starts every response frame.
+var responseHeader = []byte{0x40, 0x68}
+
+// Offsets of the parts of the request command.
+const (
+	offsetCommand = 2
+	offsetEnd     = 5
+	offsetFiller  = 6
+)
+
+// Offsets and sizes of the fields in a response frame.
+const (
+	offsetVOC        = 2
+	offsetResistance = 4
+	offsetStatus     = 8
+	offsetUnknown    = 9
+
+	sizeVOC        = 2
+	sizeResistance = 4
+	sizeStatus     = 1
+)
+
+// Range of valid VOC values in ppm CO2-equivalent. The sensor docs say
+// everything else is garbage.
+const (
+	MinVOC = 450
+	MaxVOC = 2000
+)
+
+// Reading is a single sample taken from the sensor.
+type Reading struct {
+	// VOC concentration in ppm CO2-equivalent.
+	VOC int16
+	// Timestamp is the time the response frame was received. It is the
+	// wall-clock time to report; use Age and Sub for intervals.
+	Timestamp time.Time
+	// Monotonic is the time the response frame was received on the
+	// monotonic clock, see Monotonic. It doesn't jump when the wall clock
+	// is stepped, but only means something within the process that took
+	// the reading, so it isn't marshalled. Zero if unknown, e.g. for a
+	// reading restored from disk.
+	Monotonic time.Duration `json:"-"`
+	// Raw is the response frame as received from the device.
+	Raw []byte
+	// Valid reports whether VOC lies within MinVOC and MaxVOC.
+	Valid bool
+	// Stabilizing reports whether the reading was taken during the
+	// sensor's warm-up period, when values are unreliable.
+	Stabilizing bool
+	// Priming marks an out-of-range first reading after the stick was
+	// opened or reset. The stick often answers the first request with a
+	// stale or zero frame, so it should be discarded. An in-range first
+	// reading is not marked.
+	Priming bool
+	// Calibrated is VOC after applying the sensor's Calibration. Range
+	// checks always use the raw VOC.
+	Calibrated float64
+	// Smoothed is the calibrated value after smoothing over previous
+	// readings. It equals Calibrated unless the consumer applies a filter
+	// like EMA.
+	Smoothed float64
+	// Anomalous marks a sudden spike of the calibrated value. It is false
+	// unless the consumer applies an Anomaly detector.
+	Anomalous bool
+	// AboveBaseline is the calibrated value minus the clean-air baseline,
+	// the more telling number on a drifting sensor. It is zero unless
+	// the consumer tracks a Baseline.
+	AboveBaseline float64
+
+	// Resistance is the raw sensor resistance reported along with the
+	// VOC value, for doing your own calibration. Experimental; zero if
+	// the frame is too short.
+	Resistance uint32
+	// Status holds the status flags of the frame. Experimental; zero if
+	// the frame is too short.
+	Status byte
+	// TemperatureC is the on-chip temperature in °C some firmware
+	// revisions report, see Sensor.TemperatureOffset. Experimental; nil
+	// unless enabled and plausible.
+	TemperatureC *float64
+
+	// Serial identifies the sensor that took the reading, see
+	// Sensor.Serial.
+	Serial string
+	// Device is the vendor and product ID of that sensor, see
+	// SensorInfo.Device.
+	Device DeviceID
+}
+
+// clockStart is the origin of the monotonic clock.
+var clockStart = time.Now()
+
+// Monotonic returns the current time on the monotonic clock of
+// Reading.Monotonic, the time since the package was initialized. Unlike
+// wall-clock time it never goes backwards or jumps, e.g. when NTP steps
+// the clock.
+func Monotonic() time.Duration {
+	return time.Since(clockStart)
+}
+
+// monotonic returns t, as returned by time.Now, on the monotonic clock.
+func monotonic(t time.Time) time.Duration {
+	return t.Sub(clockStart)
+}
+
+// Sub returns the time between o and r, on the monotonic clock if both
+// have a Monotonic time and by their timestamps otherwise.
+func (r *Reading) Sub(o *Reading) time.Duration {
+	if r.Monotonic != 0 && o.Monotonic != 0 {
+		return r.Monotonic - o.Monotonic
+	}
+	return r.Timestamp.Sub(o.Timestamp)
+}
+
+// Age returns the time elapsed since r was taken, see Sub.
+func (r *Reading) Age() time.Duration {
+	return r.ageAt(time.Now())
+}
+
+// ageAt returns the time between r and now, as returned by time.Now.
+func (r *Reading) ageAt(now time.Time) time.Duration {
+	if r.Monotonic != 0 {
+		return monotonic(now) - r.Monotonic
+	}
+	return now.Sub(r.Timestamp)
+}
+
+// Calibration is a linear correction of the raw VOC value, for matching a
+// stick to a reference instrument.
+type Calibration struct {
+	Scale  float64
+	Offset float64
+}
+
+// NoCalibration leaves values unchanged.
+var NoCalibration = Calibration{Scale: 1}
+
+// Apply returns the calibrated value of voc: voc*Scale + Offset.
+func (c Calibration) Apply(voc int16) float64 {
+	return float64(voc)*c.Scale + c.Offset
+}
+
+// Range classifies a VOC value.
+type Range int
+
+const (
+	// InRange values lie within MinVOC and MaxVOC.
+	InRange Range = iota
+	// BelowRange values are below MinVOC: the air is cleaner than the
+	// sensor can tell.
+	BelowRange
+	// AboveRange values are above MaxVOC: the sensor is saturated.
+	AboveRange
+	// Bogus values are zero or negative, which a working sensor never
+	// reports, e.g. 0xffff. They point at a corrupted frame.
+	Bogus
+)
+
+func (c Range) String() string {
+	names := [...]string{"in_range", "below_range", "above_range", "bogus"}
+	if c < 0 || int(c) >= len(names) {
+		return fmt.Sprintf("Range(%d)", int(c))
+	}
+	return names[c]
+}
+
+// RangeOf classifies voc.
+func RangeOf(voc int16) Range {
+	switch {
+	case voc <= 0:
+		return Bogus
+	case voc < MinVOC:
+		return BelowRange
+	case voc > MaxVOC:
+		return AboveRange
+	default:
+		return InRange
+	}
+}
+
+// Err returns an error wrapping ErrValueOutOfRange if r isn't valid, along
+// with ErrBelowRange, ErrAboveRange or ErrBogusValue.
+func (r *Reading) Err() error {
+	if r.Valid {
+		return nil
+	}
+	switch RangeOf(r.VOC) {
+	case BelowRange:
+		return fmt.Errorf("%w: %w: VOC value %d below %d", ErrValueOutOfRange, ErrBelowRange, r.VOC, MinVOC)
+	case AboveRange:
+		return fmt.Errorf("%w: %w: VOC value %d above %d", ErrValueOutOfRange, ErrAboveRange, r.VOC, MaxVOC)
+	case Bogus:
+		return fmt.Errorf("%w: %w: VOC value %d (raw % x)", ErrValueOutOfRange, ErrBogusValue, r.VOC, r.Raw)
+	}
+	return fmt.Errorf("%w: VOC value %d not within %d and %d", ErrValueOutOfRange, r.VOC, MinVOC, MaxVOC)
+}
+
+func read_le_uint32(data []byte) (ret uint32) {
+	buf := bytes.NewBuffer(data)
+	binary.Read(buf, binary.LittleEndian, &ret)
+	return
+}
+
+// Range of plausible on-chip temperatures in °C. Anything else means the
+// byte isn't a temperature.
+const (
+	minTemperature = -40
+	maxTemperature = 85
+)
+
+// parseTemperature decodes the temperature byte at offset, a signed value
+// in °C. It returns nil if the frame is too short or the value is
+// implausible.
+func parseTemperature(frame []byte, offset int) *float64 {
+	if offset <= 0 || offset >= len(frame) {
+		return nil
+	}
+	t := float64(int8(frame[offset]))
+	if t < minTemperature || t > maxTemperature {
+		return nil
+	}
+	return &t
+}
+
+// ParseConfig says where a response frame carries the VOC value, for
+// firmware variants placing it differently.
+type ParseConfig struct {
+	// VOCOffset is the offset of the VOC value in the frame.
+	VOCOffset int
+	// ByteOrder is the byte order of the VOC value; nil means
+	// little-endian.
+	ByteOrder binary.ByteOrder
+}
+
+// DefaultParseConfig is the layout of the frames of the sticks seen so
+// far, see FrameSize.
+var DefaultParseConfig = ParseConfig{VOCOffset: offsetVOC, ByteOrder: binary.LittleEndian}
+
+func (pc ParseConfig) byteOrder() binary.ByteOrder {
+	if pc.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return pc.ByteOrder
+}
+
+// parseReading decodes a response frame received at ts, taking the VOC
+// value from where pc says.
+func parseReading(frame []byte, ts time.Time, pc ParseConfig) (*Reading, error) {
+	need := max(pc.VOCOffset+sizeVOC, len(responseHeader))
+	if pc.VOCOffset < 0 || len(frame) < need {
+		return nil, fmt.Errorf("%w: got %d bytes, need at least %d", ErrBadFrame, len(frame), need)
+	}
+	if !bytes.Equal(frame[:len(responseHeader)], responseHeader) {
+		return nil, fmt.Errorf("%w: header % x, want % x", ErrBadFrame, frame[:len(responseHeader)], responseHeader)
+	}
+	voc := int16(pc.byteOrder().Uint16(frame[pc.VOCOffset : pc.VOCOffset+sizeVOC]))
+	r := &Reading{
+		VOC:        voc,
+		Timestamp:  ts,
+		Monotonic:  monotonic(ts),
+		Raw:        frame,
+		Valid:      voc >= MinVOC && voc <= MaxVOC,
+		Calibrated: float64(voc),
+		Smoothed:   float64(voc),
+	}
+	if len(frame) >= offsetResistance+sizeResistance {
+		r.Resistance = read_le_uint32(frame[offsetResistance : offsetResistance+sizeResistance])
+	}
+	if len(frame) >= offsetStatus+sizeStatus {
+		r.Status = frame[offsetStatus]
+	}
+	return r, nil
+}
@@ -0,0 +1,115 @@
+package airsensor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/gousb"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// IsDisconnected reports whether err means that the device is gone, e.g.
+// because the stick was unplugged.
+func IsDisconnected(err error) bool {
+	return errors.Is(err, gousb.ErrorNoDevice) ||
+		errors.Is(err, gousb.TransferNoDevice)
+}
+
+// Reconnecting is a SensorReader that survives the stick being unplugged
+// and plugged in again. When a read reports that the device is gone, the
+// sensor is closed and reopened on later reads, waiting with exponential
+// backoff between failed attempts. Reads while waiting fail right away.
+type Reconnecting struct {
+	open      func() (SensorReader, error)
+	baseDelay time.Duration
+
+	mu sync.Mutex
+	s  SensorReader
+	// stale is a disconnected sensor whose Close failed. Its claim is
+	// released before reopening, so the new one doesn't find the stick
+	// busy.
+	stale    SensorReader
+	attempts int
+	next     time.Time
+}
+
+// NewReconnecting wraps the already opened s; open is used to reopen the
+// sensor after a disconnect.
+func NewReconnecting(s SensorReader, open func() (SensorReader, error), baseDelay time.Duration) *Reconnecting {
+	return &Reconnecting{s: s, open: open, baseDelay: baseDelay}
+}
+
+// reopen tries to open the sensor if the backoff delay has passed.
+func (r *Reconnecting) reopen() error {
+	if wait := time.Until(r.next); wait > 0 {
+		return fmt.Errorf("%w: sensor disconnected, reopening in %s", ErrDeviceNotFound, wait.Round(time.Millisecond))
+	}
+	if r.stale != nil {
+		if err := r.stale.Close(); err != nil {
+			slog.Warn("Releasing stale sensor failed, reopening anyway", "err", err)
+		} else {
+			r.stale = nil
+		}
+	}
+	s, err := r.open()
+	if err != nil {
+		r.attempts++
+		r.next = time.Now().Add(backoff(r.attempts, r.baseDelay))
+		return fmt.Errorf("reopening sensor failed: %w", err)
+	}
+	slog.Info("Reopened sensor", "failed_attempts", r.attempts)
+	r.s, r.attempts = s, 0
+	return nil
+}
+
+// ReadVOC reads from the current sensor, reopening it first if needed.
+func (r *Reconnecting) ReadVOC() (*Reading, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.s == nil {
+		if err := r.reopen(); err != nil {
+			return nil, err
+		}
+	}
+	reading, err := r.s.ReadVOC()
+	if err != nil && IsDisconnected(err) {
+		slog.Warn("Sensor disconnected", "err", err)
+		if cerr := r.s.Close(); cerr != nil {
+			slog.Error("Closing disconnected sensor failed", "err", cerr)
+			r.stale = r.s
+		}
+		r.s = nil
+		r.next = time.Time{}
+	}
+	return reading, err
+}
+
+// SetDebug changes the libusb debug level of the current sensor, if it
+// supports that like Sensor does. A sensor reopened later keeps the level
+// set by the open function.
+func (r *Reconnecting) SetDebug(level int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if d, ok := r.s.(interface{ SetDebug(int) }); ok {
+		d.SetDebug(level)
+	}
+}
+
+// Close closes the current sensor and a stale one, if any.
+func (r *Reconnecting) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var err error
+	if r.stale != nil {
+		err = r.stale.Close()
+		r.stale = nil
+	}
+	if r.s != nil {
+		if cerr := r.s.Close(); err == nil {
+			err = cerr
+		}
+		r.s = nil
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package airsensor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReconnecting(t *testing.T) {
+	ep := newFakeEndpoint(nil, mockFrame(700), nil)
+	plugged := false
+	opens := 0
+	open := func() (SensorReader, error) {
+		opens++
+		if !plugged {
+			return nil, ErrDeviceNotFound
+		}
+		return newFakeSensor(newFakeEndpoint(nil, mockFrame(800), nil)), nil
+	}
+	r := NewReconnecting(newFakeSensor(ep), open, time.Millisecond)
+	defer r.Close()
+
+	if got, err := r.ReadVOC(); err != nil || got.VOC != 700 {
+		t.Fatalf("ReadVOC() = %v, %v, want 700", got, err)
+	}
+
+	ep.unplug()
+	if _, err := r.ReadVOC(); !IsDisconnected(err) {
+		t.Fatalf("ReadVOC() after unplugging: error = %v, want a disconnect", err)
+	}
+	if _, err := r.ReadVOC(); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("ReadVOC() while unplugged: error = %v, want %v", err, ErrDeviceNotFound)
+	}
+	if opens != 1 {
+		t.Fatalf("%d attempts to reopen, want 1", opens)
+	}
+
+	plugged = true
+	// Wait out the backoff after the failed attempt.
+	time.Sleep(10 * time.Millisecond)
+	if got, err := r.ReadVOC(); err != nil || got.VOC != 800 {
+		t.Fatalf("ReadVOC() after plugging in again = %v, %v, want 800", got, err)
+	}
+	if opens != 2 {
+		t.Errorf("%d attempts to reopen, want 2", opens)
+	}
+}
@@ -0,0 +1,88 @@
+package airsensor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/gousb"
+	"testing"
+	"time"
+)
+
+// fakeStick hands out fake sensors claiming its interface. Opening fails
+// while the interface is still claimed, and releasing it fails the first
+// failReleases times, like a stick that still reports EBUSY.
+type fakeStick struct {
+	claimed      bool
+	failReleases int
+	// ep is the endpoint of the sensor opened last.
+	ep *fakeEndpoint
+}
+
+func (st *fakeStick) open() (SensorReader, error) {
+	if st.claimed {
+		return nil, fmt.Errorf("claiming interface: %w", gousb.ErrorBusy)
+	}
+	st.claimed = true
+	st.ep = newFakeEndpoint(nil, mockFrame(700), nil)
+	s := newFakeSensor(st.ep)
+	s.done = func() error {
+		if st.failReleases > 0 {
+			st.failReleases--
+			return errors.New("interface still claimed")
+		}
+		st.claimed = false
+		return nil
+	}
+	return s, nil
+}
+
+func TestReopenAfterRelease(t *testing.T) {
+	st := &fakeStick{failReleases: 1}
+	s, err := st.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.open(); !errors.Is(err, gousb.ErrorBusy) {
+		t.Fatalf("open() of a claimed stick: error = %v, want %v", err, gousb.ErrorBusy)
+	}
+	// The first release fails and is retried.
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if st.claimed {
+		t.Fatal("interface still claimed after Close()")
+	}
+	s, err = st.open()
+	if err != nil {
+		t.Fatalf("open() after Close() error = %v", err)
+	}
+	defer s.Close()
+	if r, err := s.ReadVOC(); err != nil || r.VOC != 700 {
+		t.Errorf("ReadVOC() after reopening = %v, %v, want 700", r, err)
+	}
+}
+
+func TestReopenReleasesStaleClaim(t *testing.T) {
+	st := &fakeStick{}
+	s, err := st.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReconnecting(s, st.open, time.Millisecond)
+	defer r.Close()
+
+	// Every release while closing the unplugged sensor fails, so its
+	// claim is left behind.
+	st.failReleases = releaseAttempts
+	st.ep.unplug()
+	if _, err := r.ReadVOC(); !IsDisconnected(err) {
+		t.Fatalf("ReadVOC() after unplugging: error = %v, want a disconnect", err)
+	}
+	if !st.claimed {
+		t.Fatal("interface released although every release failed")
+	}
+	// Reopening releases the stale claim first, so the stick isn't busy.
+	if got, err := r.ReadVOC(); err != nil || got.VOC != 700 {
+		t.Fatalf("ReadVOC() after reopening = %v, %v, want 700", got, err)
+	}
+}
@@ -0,0 +1,82 @@
+package airsensor
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// maxBackoff caps the delay between two attempts.
+const maxBackoff = time.Minute
+
+// backoff returns the delay before the given retry attempt (counting from
+// 1): base doubled per attempt, capped at maxBackoff, with up to half of it
+// replaced by random jitter.
+func backoff(attempt int, base time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// retry calls open until it succeeds, waiting with exponential backoff
+// between attempts. It gives up after maxAttempts attempts, or never if
+// maxAttempts is 0, and when ctx is done.
+func retry(ctx context.Context, maxAttempts int, baseDelay time.Duration, open func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := open()
+		if err == nil {
+			return nil
+		}
+		if maxAttempts > 0 && attempt >= maxAttempts {
+			return err
+		}
+		delay := backoff(attempt, baseDelay)
+		slog.Warn("Opening sensor failed", "attempt", attempt, "err", err, "retry_in", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// OpenWithRetry calls Open until it succeeds, see retry.
+func OpenWithRetry(ctx context.Context, maxAttempts int, baseDelay time.Duration, sel ...Selector) (*Sensor, error) {
+	return Options{}.OpenWithRetry(ctx, maxAttempts, baseDelay, sel...)
+}
+
+// OpenAllWithRetry calls OpenAll until at least one stick is opened, see
+// retry.
+func OpenAllWithRetry(ctx context.Context, maxAttempts int, baseDelay time.Duration, sel ...Selector) ([]*Sensor, error) {
+	return Options{}.OpenAllWithRetry(ctx, maxAttempts, baseDelay, sel...)
+}
+
+// OpenWithRetry is like the package-level OpenWithRetry, using o.
+func (o Options) OpenWithRetry(ctx context.Context, maxAttempts int, baseDelay time.Duration, sel ...Selector) (*Sensor, error) {
+	var s *Sensor
+	err := retry(ctx, maxAttempts, baseDelay, func() (err error) {
+		s, err = o.Open(sel...)
+		return err
+	})
+	return s, err
+}
+
+// OpenAllWithRetry is like the package-level OpenAllWithRetry, using o.
+func (o Options) OpenAllWithRetry(ctx context.Context, maxAttempts int, baseDelay time.Duration, sel ...Selector) ([]*Sensor, error) {
+	var sensors []*Sensor
+	err := retry(ctx, maxAttempts, baseDelay, func() (err error) {
+		sensors, err = o.OpenAll(sel...)
+		return err
+	})
+	return sensors, err
+}
@@ -0,0 +1,917 @@
+// Package airsensor talks to the AppliedSensor iAQ-Stick VOC sensor.
+//
+// A reading can be bounded by a deadline, which also limits how long the
+// underlying USB transfers may block:
+//
+//	s, err := airsensor.Open()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer s.Close()
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+//	defer cancel()
+//	r, err := s.ReadVOCContext(ctx)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	fmt.Printf("VOC: %d ppm\n", r.VOC)
+//
+// Stream polls the sensor and delivers the readings on a channel:
+//
+//	for r := range s.Stream(ctx, 30*time.Second) {
+//		fmt.Printf("VOC: %d ppm\n", r.VOC)
+//	}
+//	if err := s.StreamErr(); err != nil {
+//		log.Fatal(err)
+//	}
+package airsensor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/gousb"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// USB IDs of the iAQ-Stick. ProductID2011 is a variant speaking the same
+// protocol.
+const (
+	VendorID      gousb.ID = 0x03eb
+	ProductID     gousb.ID = 0x2013
+	ProductID2011 gousb.ID = 0x2011
+)
+
+// A SensorReader takes readings from a VOC sensor. Sensor is the
+// implementation talking to a real iAQ-Stick.
+type SensorReader interface {
+	ReadVOC() (*Reading, error)
+	Close() error
+}
+
+var _ SensorReader = (*Sensor)(nil)
+
+// Default timeouts of a single USB transfer.
+const (
+	DefaultReadTimeout  = time.Second
+	DefaultWriteTimeout = time.Second
+)
+
+// Endpoints of the default interface used unless Options say otherwise.
+const (
+	DefaultInEndpoint  = 1
+	DefaultOutEndpoint = 2
+)
+
+// DrainAuto makes Sensor.PreRequestReads read until nothing is pending,
+// at most maxDrainReads times.
+const (
+	DrainAuto     = -1
+	maxDrainReads = 8
+)
+
+// DefaultFlushTimeout is the default of Sensor.FlushTimeout.
+const DefaultFlushTimeout = 100 * time.Millisecond
+
+// DefaultMinResetInterval is the default of Sensor.MinResetInterval.
+const DefaultMinResetInterval = time.Minute
+
+// Releasing an interface is retried releaseAttempts times, releaseDelay
+// apart.
+const (
+	releaseAttempts = 3
+	releaseDelay    = 100 * time.Millisecond
+)
+
+// Sensor is an opened iAQ-Stick. A Sensor must be Close()d after use.
+//
+// Its methods are safe for concurrent use: all device I/O is serialized,
+// so the frames of concurrent reads, e.g. a poll loop and an on-demand
+// read, never interleave; one read waits for the other. Its fields are
+// not guarded and must be set before the sensor is shared.
+type Sensor struct {
+	// Timeouts of a single USB read and write. A transfer exceeding its
+	// timeout fails with ErrReadTimeout. Zero means no timeout. Set
+	// them before the first read.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Warmup is the time after opening during which readings are marked
+	// as Stabilizing.
+	Warmup time.Duration
+
+	// Calibration is applied to every reading to fill in
+	// Reading.Calibrated. It defaults to NoCalibration.
+	Calibration Calibration
+
+	// RequestCommand is sent to ask for a reading. It defaults to
+	// DefaultRequestCommand. Set it before the first read.
+	RequestCommand []byte
+
+	// PreRequestReads is the number of reads discarding pending bytes
+	// before each request. A stick sometimes holds more than one stale
+	// frame, and a leftover one is taken for the response. DrainAuto
+	// reads until a read times out. Open sets it to 1.
+	PreRequestReads int
+
+	// Flush enables the read after each response, which Open turns on.
+	// Some sticks follow the response with a second frame; left pending,
+	// it would be taken for the response to the next request and fail
+	// that read with ErrBadFrame. Other sticks send nothing, so the
+	// flush read only ends when FlushTimeout expires. Without it, the
+	// read before each request still catches a pending frame, but only
+	// after ReadTimeout if the stick sent none.
+	Flush bool
+	// FlushTimeout bounds the flush read, so sticks sending no second
+	// frame don't hold up every read for ReadTimeout. It defaults to
+	// DefaultFlushTimeout; zero means ReadTimeout.
+	FlushTimeout time.Duration
+
+	// FrameSize is the number of bytes read as a response frame. It
+	// defaults to FrameSize. Bytes past it are dropped; fields past the
+	// end of a shorter frame are left zero. Set it before the first read.
+	FrameSize int
+
+	// Parse says where the VOC value is in a response frame. Open sets
+	// it to DefaultParseConfig. Set it before the first read.
+	Parse ParseConfig
+
+	// TemperatureOffset is the offset of a temperature byte in the
+	// response frame, which some firmware revisions carry at a position
+	// of their own, see Reading.TemperatureC. 0 disables parsing it.
+	// Experimental.
+	TemperatureOffset int
+
+	// ResetAfter is the number of consecutive bad frames after which the
+	// device is reset and its interface claimed again, which gets a
+	// wedged stick answering again. 0 disables resets.
+	ResetAfter int
+	// MinResetInterval is the minimum time between two resets. It
+	// defaults to DefaultMinResetInterval.
+	MinResetInterval time.Duration
+	// OnReset, if set, is called after every reset attempt with its
+	// error.
+	OnReset func(err error)
+
+	// mu serializes all device I/O, the request/response handshake as
+	// well as claiming, resetting and closing, and guards the fields
+	// below.
+	mu sync.Mutex
+
+	opened time.Time
+	ready  bool
+	closed bool
+	// primed is set by the first reading after opening or a reset, see
+	// Reading.Priming.
+	primed bool
+
+	// control is set while requests and responses go over the control
+	// endpoint, see Transport; settled once a read succeeded on it or on
+	// the endpoints, ending the detection of TransportAuto.
+	control bool
+	settled bool
+	// inTimeout and outTimeout bound the next transfer, see setTimeout.
+	inTimeout  time.Duration
+	outTimeout time.Duration
+
+	badFrames int
+	lastReset time.Time
+
+	// streamMu guards streamErr, the error that ended the last Stream.
+	streamMu  sync.Mutex
+	streamErr error
+
+	ctx  *gousb.Context
+	opts Options
+	dev  *gousb.Device
+	info SensorInfo
+	intf *gousb.Interface
+	done func() error
+	// ep is the endpoint pair of the claimed interface, nil over the
+	// control endpoint.
+	ep endpoint
+}
+
+// endpoint is what the handshake reads response frames from and writes
+// request commands to, the IN and OUT endpoints of a stick. Tests put a
+// fakeEndpoint in its place.
+type endpoint interface {
+	Read([]byte) (int, error)
+	Write([]byte) (int, error)
+}
+
+// timedEndpoint is an endpoint whose transfers are bounded by timeouts.
+type timedEndpoint interface {
+	endpoint
+	setTimeouts(in, out time.Duration)
+}
+
+// usbEndpoint reads from an IN and writes to an OUT endpoint.
+type usbEndpoint struct {
+	in  *gousb.InEndpoint
+	out *gousb.OutEndpoint
+}
+
+func (e usbEndpoint) Read(buf []byte) (int, error)  { return e.in.Read(buf) }
+func (e usbEndpoint) Write(buf []byte) (int, error) { return e.out.Write(buf) }
+
+func (e usbEndpoint) setTimeouts(in, out time.Duration) {
+	e.in.Timeout, e.out.Timeout = in, out
+}
+
+// Options control how a stick is opened. The zero value opens it the way
+// Open does.
+type Options struct {
+	// AutoDetach detaches a kernel driver holding the interface before
+	// claiming it, and reattaches it on Close. Only supported on Linux.
+	AutoDetach bool
+
+	// Config is the number of the USB configuration to use; 0 keeps the
+	// active one.
+	Config int
+	// Interface and AltSetting select the interface to claim, by default
+	// #0 with alternate setting #0.
+	Interface  int
+	AltSetting int
+	// InEndpoint and OutEndpoint are the numbers of the endpoints the
+	// responses are read from and the requests written to. 0 means
+	// DefaultInEndpoint and DefaultOutEndpoint.
+	InEndpoint  int
+	OutEndpoint int
+
+	// Transport selects how requests and responses are exchanged,
+	// TransportAuto by default.
+	Transport Transport
+}
+
+// Transport is the way requests and responses are exchanged with a stick.
+type Transport int
+
+const (
+	// TransportAuto uses the endpoints, but falls back to the control
+	// endpoint if they are missing or the first read times out.
+	TransportAuto Transport = iota
+	// TransportEndpoints writes requests to the OUT endpoint and reads
+	// responses from the IN endpoint.
+	TransportEndpoints
+	// TransportControl exchanges requests and responses as HID output
+	// and input reports over the control endpoint (EP0), for sticks
+	// answering only there.
+	TransportControl
+)
+
+func (t Transport) String() string {
+	names := [...]string{"auto", "endpoints", "control"}
+	if t < 0 || int(t) >= len(names) {
+		return fmt.Sprintf("Transport(%d)", int(t))
+	}
+	return names[t]
+}
+
+func (t Transport) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText parses the name of a Transport, as returned by String.
+func (t *Transport) UnmarshalText(b []byte) error {
+	for tr := TransportAuto; tr <= TransportControl; tr++ {
+		if string(b) == tr.String() {
+			*t = tr
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid transport %q, must be auto, endpoints or control", b)
+}
+
+// HID class requests exchanging reports over the control endpoint.
+const (
+	requestTypeHIDOut = 0x21 // host-to-device, class, interface
+	requestTypeHIDIn  = 0xa1 // device-to-host, class, interface
+	requestGetReport  = 0x01
+	requestSetReport  = 0x09
+	reportInput       = 0x01 << 8 // report type in the high byte, ID 0
+	reportOutput      = 0x02 << 8
+)
+
+// Open opens the first iAQ-Stick accepted by all selectors and claims its
+// default interface. Without selectors the first stick found is used.
+func Open(sel ...Selector) (*Sensor, error) {
+	return Options{}.Open(sel...)
+}
+
+// OpenAll opens every iAQ-Stick accepted by all selectors. It fails if no
+// stick could be opened; sticks that fail to open while others succeed are
+// logged and skipped.
+func OpenAll(sel ...Selector) ([]*Sensor, error) {
+	return Options{}.OpenAll(sel...)
+}
+
+// newSensor returns a Sensor with the defaults Open documents, not yet
+// attached to a device.
+func newSensor(o Options) *Sensor {
+	return &Sensor{
+		ReadTimeout:      DefaultReadTimeout,
+		WriteTimeout:     DefaultWriteTimeout,
+		Calibration:      NoCalibration,
+		RequestCommand:   DefaultRequestCommand(),
+		FrameSize:        FrameSize,
+		Parse:            DefaultParseConfig,
+		PreRequestReads:  1,
+		Flush:            true,
+		FlushTimeout:     DefaultFlushTimeout,
+		MinResetInterval: DefaultMinResetInterval,
+		opened:           time.Now(),
+		opts:             o,
+	}
+}
+
+// Open is like the package-level Open, using o.
+func (o Options) Open(sel ...Selector) (*Sensor, error) {
+	// Only one context should be needed for an application.  It should
+	// always be closed.
+	ctx := gousb.NewContext()
+	s := newSensor(o)
+	s.ctx = ctx
+	dev, err := openSelected(ctx, sel)
+	if err != nil {
+		ctx.Close()
+		return nil, err
+	}
+	s.dev, s.info = dev, infoOf(dev)
+
+	if o.AutoDetach {
+		if err := dev.SetAutoDetach(true); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("%s.SetAutoDetach(true): %v", dev, err)
+		}
+	}
+
+	if err := s.claim(); err != nil {
+		s.Close()
+		if !o.AutoDetach {
+			return nil, fmt.Errorf("%v (if a kernel driver holds the stick, enable AutoDetach)", err)
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// claim claims the interface selected by the options and opens its
+// endpoints. A claim still held from before is released first.
+func (s *Sensor) claim() error {
+	if s.done != nil {
+		slog.Debug("Releasing stale claim", "sensor", s.info)
+		if err := s.release(); err != nil {
+			return err
+		}
+	}
+	o := s.opts
+	cfgNum := o.Config
+	if cfgNum == 0 {
+		var err error
+		cfgNum, err = s.dev.ActiveConfigNum()
+		if err != nil {
+			return fmt.Errorf("%s.ActiveConfigNum(): %v", s.dev, err)
+		}
+	}
+	cfg, err := s.dev.Config(cfgNum)
+	if err != nil {
+		return fmt.Errorf("%s.Config(%d): %v", s.dev, cfgNum, err)
+	}
+	intf, err := cfg.Interface(o.Interface, o.AltSetting)
+	if err != nil {
+		cfg.Close()
+		return fmt.Errorf("%s.Interface(%d, %d): %v", cfg, o.Interface, o.AltSetting, err)
+	}
+	// Config.Close fails while gousb still counts an interface of it
+	// as claimed, which tells whether the release went through.
+	done := func() error {
+		intf.Close()
+		return cfg.Close()
+	}
+	if o.Transport == TransportControl {
+		s.intf, s.done, s.control = intf, done, true
+		return nil
+	}
+	in, out, err := openEndpoints(intf, o)
+	if err != nil && o.Transport == TransportAuto {
+		slog.Info("Stick lacks its endpoints, using the control endpoint", "sensor", s.info, "err", err)
+		s.intf, s.done, s.control, s.settled = intf, done, true, true
+		return nil
+	}
+	if err != nil {
+		done()
+		return err
+	}
+	s.intf, s.done, s.ep = intf, done, usbEndpoint{in, out}
+	return nil
+}
+
+// openEndpoints opens the IN and OUT endpoints of intf selected by o.
+func openEndpoints(intf *gousb.Interface, o Options) (*gousb.InEndpoint, *gousb.OutEndpoint, error) {
+	// Open an IN endpoint.
+	inNum := o.InEndpoint
+	if inNum == 0 {
+		inNum = DefaultInEndpoint
+	}
+	in, err := intf.InEndpoint(inNum)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s.InEndpoint(%d): %v", intf, inNum, err)
+	}
+
+	// Open an OUT endpoint.
+	outNum := o.OutEndpoint
+	if outNum == 0 {
+		outNum = DefaultOutEndpoint
+	}
+	out, err := intf.OutEndpoint(outNum)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s.OutEndpoint(%d): %v", intf, outNum, err)
+	}
+	return in, out, nil
+}
+
+// release releases the claimed interface, if any, and verifies that it
+// was freed, retrying a few times. If it wasn't, the claim is kept, so a
+// later release tries again, and the device can't be closed.
+func (s *Sensor) release() error {
+	if s.done == nil {
+		return nil
+	}
+	var err error
+	for i := 0; i < releaseAttempts; i++ {
+		if i > 0 {
+			time.Sleep(releaseDelay)
+		}
+		if err = s.done(); err == nil {
+			s.intf, s.done, s.ep = nil, nil, nil
+			return nil
+		}
+	}
+	slog.Error("Releasing interface failed", "sensor", s.info, "attempts", releaseAttempts, "err", err)
+	return fmt.Errorf("releasing interface: %v", err)
+}
+
+// reset resets the device and claims its interface again. The interface
+// has to be released for the reset; if claiming it fails afterwards, the
+// next read tries again.
+func (s *Sensor) reset() error {
+	if err := s.release(); err != nil {
+		return err
+	}
+	if err := s.dev.Reset(); err != nil {
+		return fmt.Errorf("%s.Reset(): %w", s.dev, err)
+	}
+	s.primed = false
+	return s.claim()
+}
+
+// OpenAll is like the package-level OpenAll, using o.
+func (o Options) OpenAll(sel ...Selector) ([]*Sensor, error) {
+	infos, err := Discover()
+	if err != nil {
+		slog.Warn("Enumerating sensors failed", "err", err)
+	}
+	var sensors []*Sensor
+	matched := 0
+	for _, info := range infos {
+		if !matches(info, sel) {
+			continue
+		}
+		matched++
+		s, err := o.Open(ByBusAddress(info.Bus, info.Address))
+		if err != nil {
+			slog.Warn("Opening sensor failed", "sensor", info, "err", err)
+			continue
+		}
+		sensors = append(sensors, s)
+	}
+	if len(sensors) == 0 {
+		if matched > 0 {
+			return nil, fmt.Errorf("%w: could not open any of the %d matching sticks", ErrDeviceNotFound, matched)
+		}
+		return nil, notFound(infos, err)
+	}
+	return sensors, nil
+}
+
+// openSelected opens the first device accepted by all selectors and
+// closes the others.
+func openSelected(ctx *gousb.Context, sel []Selector) (*gousb.Device, error) {
+	devs, err := openDevices(ctx)
+	var (
+		found *gousb.Device
+		infos []SensorInfo
+	)
+	for _, dev := range devs {
+		info := infoOf(dev)
+		if found == nil && matches(info, sel) {
+			found = dev
+			continue
+		}
+		infos = append(infos, info)
+		dev.Close()
+	}
+	if found != nil {
+		return found, nil
+	}
+	return nil, notFound(infos, err)
+}
+
+// notFound explains why no stick was accepted: enumeration failed, none
+// is connected, or none of the connected ones, which are listed by ID,
+// matched the selectors.
+func notFound(infos []SensorInfo, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: could not open %s: %w", ErrDeviceNotFound, SupportedDevices, err)
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("%w: no %s connected", ErrDeviceNotFound, SupportedDevices)
+	}
+	ids := make([]string, len(infos))
+	for i, info := range infos {
+		ids[i] = info.ID()
+	}
+	return fmt.Errorf("%w: no matching stick, connected are %s", ErrDeviceNotFound, strings.Join(ids, ", "))
+}
+
+func matches(info SensorInfo, sel []Selector) bool {
+	for _, accept := range sel {
+		if !accept(info) {
+			return false
+		}
+	}
+	return true
+}
+
+// Serial returns the USB serial number of the sensor. When the device has
+// no readable serial number, its bus:address pair is returned instead.
+func (s *Sensor) Serial() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return "", fmt.Errorf("Serial() called after Close")
+	}
+	return s.info.ID(), nil
+}
+
+// FirmwareVersion returns the firmware version of the sensor. No command
+// querying it is known for the iAQ-Stick, so this is the device release
+// number (bcdDevice) from the USB device descriptor, e.g. "1.00".
+func (s *Sensor) FirmwareVersion() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return "", fmt.Errorf("FirmwareVersion() called after Close")
+	}
+	return s.info.Firmware, nil
+}
+
+// Info describes the sensor as it was found when it was opened.
+func (s *Sensor) Info() SensorInfo {
+	return s.info
+}
+
+// SetDebug changes the libusb debug level. It does nothing after Close.
+func (s *Sensor) SetDebug(level int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil {
+		s.ctx.Debug(level)
+	}
+}
+
+// Close releases the interface, the device and the USB context. If the
+// interface or the device can't be released, the error is returned and
+// Close can be called again to retry. A read in progress is completed
+// first.
+func (s *Sensor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if err := s.release(); err != nil {
+		return err
+	}
+	if s.dev != nil {
+		if err := s.dev.Close(); err != nil {
+			return err
+		}
+		s.dev = nil
+	}
+	var err error
+	if s.ctx != nil {
+		err = s.ctx.Close()
+		s.ctx = nil
+	}
+	return err
+}
+
+// setTimeout sets the timeouts of the next USB transfer to ReadTimeout
+// and WriteTimeout, shortened to the time left until the deadline of ctx.
+// gousb transfers can't be cancelled once submitted, so the timeouts are
+// all that bounds a hung read.
+func (s *Sensor) setTimeout(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+	var left time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		left = time.Until(deadline)
+		if left <= 0 {
+			return contextError(context.DeadlineExceeded)
+		}
+	}
+	s.inTimeout = shorter(s.ReadTimeout, left)
+	s.outTimeout = shorter(s.WriteTimeout, left)
+	return nil
+}
+
+// read reads from the IN endpoint, or fetches an input report over the
+// control endpoint, within inTimeout.
+func (s *Sensor) read(buf []byte) (int, error) {
+	if s.control {
+		s.dev.ControlTimeout = s.inTimeout
+		return s.dev.Control(requestTypeHIDIn, requestGetReport, reportInput, uint16(s.opts.Interface), buf)
+	}
+	if t, ok := s.ep.(timedEndpoint); ok {
+		t.setTimeouts(s.inTimeout, s.outTimeout)
+	}
+	return s.ep.Read(buf)
+}
+
+// write writes to the OUT endpoint, or sends an output report over the
+// control endpoint, within outTimeout.
+func (s *Sensor) write(buf []byte) (int, error) {
+	if s.control {
+		s.dev.ControlTimeout = s.outTimeout
+		return s.dev.Control(requestTypeHIDOut, requestSetReport, reportOutput, uint16(s.opts.Interface), buf)
+	}
+	if t, ok := s.ep.(timedEndpoint); ok {
+		t.setTimeouts(s.inTimeout, s.outTimeout)
+	}
+	return s.ep.Write(buf)
+}
+
+// shorter returns the shorter of two timeouts, where zero means none.
+func shorter(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// frameSize returns the size of a response frame, see Sensor.FrameSize.
+func (s *Sensor) frameSize() int {
+	if s.FrameSize <= 0 {
+		return FrameSize
+	}
+	return s.FrameSize
+}
+
+// discard reads pending bytes from the device and throws them away,
+// waiting at most timeout or ReadTimeout if it is zero. It reports
+// whether anything was pending; a timeout just means that nothing was.
+// Over the control endpoint nothing is ever pending: an input report is
+// only sent when asked for.
+func (s *Sensor) discard(ctx context.Context, step string, timeout time.Duration) (bool, error) {
+	if s.control {
+		return false, nil
+	}
+	if err := s.setTimeout(ctx); err != nil {
+		return false, err
+	}
+	s.inTimeout = shorter(s.inTimeout, timeout)
+	buf := make([]byte, s.frameSize())
+	num, err := s.read(buf)
+	if err != nil && isTimeout(err) {
+		slog.Debug("No pending bytes", "step", step)
+		return false, nil
+	}
+	if err != nil {
+		return false, transferError(step+": failed to read pending bytes into buffer", err)
+	}
+	slog.Debug("Read bytes into temporary buffer", "step", step, "bytes", num)
+	return num > 0, nil
+}
+
+// drain discards pending bytes before a request, see PreRequestReads.
+func (s *Sensor) drain(ctx context.Context) error {
+	n := s.PreRequestReads
+	if n == DrainAuto {
+		n = maxDrainReads
+	}
+	for i := 0; i < n; i++ {
+		pending, err := s.discard(ctx, "pre-request", 0)
+		if err != nil {
+			return err
+		}
+		if !pending && s.PreRequestReads == DrainAuto {
+			return nil
+		}
+	}
+	return nil
+}
+
+// request sends the request command to the device.
+func (s *Sensor) request(ctx context.Context, buf []byte) error {
+	if err := s.setTimeout(ctx); err != nil {
+		return err
+	}
+	num, err := s.write(buf)
+	if err != nil {
+		return transferError("failed to write request command", err)
+	}
+	if num != len(buf) {
+		return fmt.Errorf("failed to write request command: wrote %d of %d bytes", num, len(buf))
+	}
+	slog.Debug("Request data", "bytes", num, "data", fmt.Sprintf("% x", buf))
+	return nil
+}
+
+// response reads the response frame to a request command. The frame may
+// arrive split over several transfers, so reading goes on until it is
+// complete or the read timeout, which bounds the whole frame rather than
+// each transfer, expires. A partial frame is returned as is and left to
+// parseReading to judge.
+func (s *Sensor) response(ctx context.Context) ([]byte, error) {
+	if err := s.setTimeout(ctx); err != nil {
+		return nil, err
+	}
+	var deadline time.Time
+	if s.inTimeout > 0 {
+		deadline = time.Now().Add(s.inTimeout)
+	}
+	size := s.frameSize()
+	frame := make([]byte, 0, size)
+	// Every transfer gets a whole frame worth of buffer, so a packet
+	// can't overflow it.
+	buf := make([]byte, size)
+	for len(frame) < size {
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 && len(frame) == 0 {
+				return nil, fmt.Errorf("failed to read response: %w", ErrReadTimeout)
+			}
+			if left <= 0 {
+				slog.Debug("Partial response", "bytes", len(frame))
+				break
+			}
+			s.inTimeout = left
+		}
+		num, err := s.read(buf)
+		if err != nil && len(frame) > 0 && isTimeout(err) {
+			slog.Debug("Partial response", "bytes", len(frame))
+			break
+		}
+		if err != nil {
+			return nil, transferError("failed to read response", err)
+		}
+		slog.Debug("Response data", "bytes", num, "data", fmt.Sprintf("% x", buf[:num]))
+		if rest := size - len(frame); num > rest {
+			slog.Debug("Dropping bytes past the end of the frame", "bytes", num-rest)
+			num = rest
+		}
+		frame = append(frame, buf[:num]...)
+	}
+	return frame, nil
+}
+
+// ReadVOC performs the request/response/flush handshake and returns the
+// decoded reading. Every step reports its failure to the caller, so a
+// transient USB error can be retried. A value outside the documented range
+// is not an error; it is returned with Valid set to false.
+func (s *Sensor) ReadVOC() (*Reading, error) {
+	return s.ReadVOCContext(context.Background())
+}
+
+// ReadVOCContext is like ReadVOC but gives up when ctx is cancelled or its
+// deadline expires. A transfer that is already in flight when ctx is
+// cancelled keeps the device busy until it completes; later reads wait for
+// it.
+func (s *Sensor) ReadVOCContext(ctx context.Context) (*Reading, error) {
+	type result struct {
+		r   *Reading
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		r, err := s.readVOC(ctx)
+		ch <- result{r, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.r, res.err
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err())
+	}
+}
+
+// ReadN takes n readings back to back, as fast as the device answers, e.g.
+// for characterizing the sensor noise. It stops at the first failed read
+// and returns the readings taken so far along with the error.
+func (s *Sensor) ReadN(n int) ([]Reading, error) {
+	rs := make([]Reading, 0, n)
+	for i := 0; i < n; i++ {
+		r, err := s.ReadVOC()
+		if err != nil {
+			return rs, fmt.Errorf("reading %d of %d: %w", i+1, n, err)
+		}
+		rs = append(rs, *r)
+	}
+	return rs, nil
+}
+
+func (s *Sensor) readVOC(ctx context.Context) (*Reading, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, fmt.Errorf("ReadVOC() called after Close")
+	}
+	if s.intf == nil && s.ep == nil {
+		if err := s.claim(); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := s.handshake(ctx)
+	if errors.Is(err, ErrReadTimeout) && s.opts.Transport == TransportAuto && !s.settled {
+		slog.Debug("Stick doesn't answer on its endpoints, trying the control endpoint", "serial", s.info.ID())
+		s.control = true
+		if r, err = s.handshake(ctx); err != nil {
+			s.control = false
+		}
+	}
+	if err == nil && !s.settled {
+		s.settled = true
+		if s.control {
+			slog.Info("Stick answers on the control endpoint, using it", "serial", s.info.ID())
+		}
+	}
+	if !errors.Is(err, ErrBadFrame) {
+		s.badFrames = 0
+		return r, err
+	}
+	s.badFrames++
+	if s.ResetAfter > 0 && s.badFrames >= s.ResetAfter && time.Since(s.lastReset) >= s.MinResetInterval {
+		slog.Warn("Resetting sensor", "serial", s.info.ID(), "bad_frames", s.badFrames)
+		s.badFrames, s.lastReset = 0, time.Now()
+		rerr := s.reset()
+		if rerr != nil {
+			slog.Error("Resetting sensor failed", "serial", s.info.ID(), "err", rerr)
+		}
+		if s.OnReset != nil {
+			s.OnReset(rerr)
+		}
+	}
+	return r, err
+}
+
+// handshake performs the request/response/flush handshake.
+func (s *Sensor) handshake(ctx context.Context) (*Reading, error) {
+	// Read invalid bytes from device
+	if err := s.drain(ctx); err != nil {
+		return nil, err
+	}
+
+	// request data step 1: send request command
+	if err := s.request(ctx, s.RequestCommand); err != nil {
+		return nil, err
+	}
+
+	// request data step 2: read response
+	frame, err := s.response(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseReading(frame, time.Now(), s.Parse)
+	if err != nil {
+		return nil, err
+	}
+	r.Serial = s.info.ID()
+	r.Device = s.info.Device()
+	r.TemperatureC = parseTemperature(frame, s.TemperatureOffset)
+	r.Calibrated = s.Calibration.Apply(r.VOC)
+	r.Smoothed = r.Calibrated
+	r.Stabilizing = r.Timestamp.Sub(s.opened) < s.Warmup
+	if !s.primed {
+		s.primed, r.Priming = true, !r.Valid
+	}
+	if !r.Stabilizing && !s.ready {
+		s.ready = true
+		if s.Warmup > 0 {
+			slog.Info("Sensor warmed up", "serial", r.Serial, "warmup", s.Warmup)
+		}
+	}
+
+	// request data step 3: flush
+	if s.Flush {
+		if _, err := s.discard(ctx, "flush", s.FlushTimeout); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
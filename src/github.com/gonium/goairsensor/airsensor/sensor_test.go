@@ -0,0 +1,239 @@
+package airsensor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	frame := mockFrame(1000)
+	tests := []struct {
+		name      string
+		configure func(s *Sensor)
+		frames    [][]byte
+		want      int16
+		wantErr   error
+		// left is the number of canned frames the handshake must not
+		// have read. A bad frame isn't flushed.
+		left int
+	}{
+		{
+			name:   "nothing pending",
+			frames: [][]byte{nil, frame, nil},
+			want:   1000,
+		},
+		{
+			name:   "stale frame drained before the request",
+			frames: [][]byte{mockFrame(500), frame, nil},
+			want:   1000,
+		},
+		{
+			name:   "second frame flushed after the response",
+			frames: [][]byte{nil, frame, mockFrame(500)},
+			want:   1000,
+		},
+		{
+			name:      "drain until nothing is pending",
+			configure: func(s *Sensor) { s.PreRequestReads = DrainAuto },
+			frames:    [][]byte{mockFrame(500), mockFrame(600), nil, frame, nil},
+			want:      1000,
+		},
+		{
+			name:      "no pre-request reads",
+			configure: func(s *Sensor) { s.PreRequestReads = 0 },
+			frames:    [][]byte{frame, nil},
+			want:      1000,
+		},
+		{
+			name:      "no flush",
+			configure: func(s *Sensor) { s.Flush = false },
+			frames:    [][]byte{nil, frame, mockFrame(500)},
+			want:      1000,
+			left:      1,
+		},
+		{
+			name:   "partial frame carrying the value",
+			frames: [][]byte{nil, frame[:8], nil, nil},
+			want:   1000,
+		},
+		{
+			name:    "partial frame cut before the value",
+			frames:  [][]byte{nil, frame[:3], nil, nil},
+			wantErr: ErrBadFrame,
+			left:    1,
+		},
+		{
+			name:    "bad header",
+			frames:  [][]byte{nil, bytes.Repeat([]byte{0xff}, FrameSize), nil},
+			wantErr: ErrBadFrame,
+			left:    1,
+		},
+		{
+			name:    "no response",
+			frames:  [][]byte{nil},
+			wantErr: ErrReadTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ep := newFakeEndpoint(tt.frames...)
+			s := newFakeSensor(ep)
+			if tt.configure != nil {
+				tt.configure(s)
+			}
+			r, err := s.ReadVOC()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ReadVOC() error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("ReadVOC() error = %v", err)
+			} else if r.VOC != tt.want {
+				t.Errorf("VOC = %d, want %d", r.VOC, tt.want)
+			}
+			if w := ep.written(); len(w) != 1 || !bytes.Equal(w[0], DefaultRequestCommand()) {
+				t.Errorf("written = % x, want one request command % x", w, DefaultRequestCommand())
+			}
+			if n := ep.pending(); n != tt.left {
+				t.Errorf("%d frames left unread, want %d", n, tt.left)
+			}
+		})
+	}
+}
+
+// split cuts frame into chunks of n bytes.
+func split(frame []byte, n int) [][]byte {
+	var chunks [][]byte
+	for len(frame) > n {
+		chunks, frame = append(chunks, frame[:n]), frame[n:]
+	}
+	return append(chunks, frame)
+}
+
+func TestResponseReassembly(t *testing.T) {
+	frame := mockFrame(1234)
+	binary.LittleEndian.PutUint32(frame[offsetResistance:], 987654)
+	tests := []struct {
+		name   string
+		chunks [][]byte
+	}{
+		{"whole", [][]byte{frame}},
+		{"two chunks", [][]byte{frame[:7], frame[7:]}},
+		{"split in the value", [][]byte{frame[:3], frame[3:]}},
+		{"byte by byte", split(frame, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeSensor(newFakeEndpoint(tt.chunks...))
+			got, err := s.response(context.Background())
+			if err != nil {
+				t.Fatalf("response() error = %v", err)
+			}
+			if !bytes.Equal(got, frame) {
+				t.Fatalf("response() = % x, want % x", got, frame)
+			}
+			r, err := parseReading(got, time.Now(), DefaultParseConfig)
+			if err != nil || r.VOC != 1234 || r.Resistance != 987654 {
+				t.Errorf("parseReading() = %+v, %v, want VOC 1234 and resistance 987654", r, err)
+			}
+		})
+	}
+}
+
+func TestResponseDropsBytesPastTheFrame(t *testing.T) {
+	frame := mockFrame(1234)
+	// The second transfer starts the next frame.
+	s := newFakeSensor(newFakeEndpoint(frame[:10], append(frame[10:], mockFrame(600)[:4]...)))
+	got, err := s.response(context.Background())
+	if err != nil || !bytes.Equal(got, frame) {
+		t.Errorf("response() = % x, %v, want % x", got, err, frame)
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	const (
+		readers = 8
+		reads   = 10
+	)
+	next := int16(MinVOC)
+	ep := &fakeEndpoint{
+		// Every response carries a value of its own, so a response
+		// handed to the wrong reader shows.
+		answer: func([]byte) []byte {
+			next++
+			return mockFrame(next)
+		},
+		delay: 100 * time.Microsecond,
+	}
+	s := newFakeSensor(ep)
+	s.FlushTimeout = time.Millisecond
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[int16]bool)
+	)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < reads; j++ {
+				var (
+					r   *Reading
+					err error
+				)
+				if i%2 == 0 {
+					r, err = s.ReadVOC()
+				} else {
+					r, err = s.ReadVOCContext(context.Background())
+				}
+				if err != nil {
+					t.Errorf("ReadVOC() error = %v", err)
+					return
+				}
+				mu.Lock()
+				if seen[r.VOC] {
+					t.Errorf("response %d read twice", r.VOC)
+				}
+				seen[r.VOC] = true
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if ep.interleaved > 0 {
+		t.Errorf("%d requests written before the previous response was read", ep.interleaved)
+	}
+	if len(seen) != readers*reads {
+		t.Errorf("%d distinct responses read, want %d", len(seen), readers*reads)
+	}
+}
+
+func TestEnumStrings(t *testing.T) {
+	tests := []struct {
+		v    fmt.Stringer
+		want string
+	}{
+		{TransportControl, "control"},
+		{Transport(7), "Transport(7)"},
+		{Transport(-1), "Transport(-1)"},
+		{BreakerHalfOpen, "half_open"},
+		{BreakerState(3), "BreakerState(3)"},
+		{Bogus, "bogus"},
+		{Range(4), "Range(4)"},
+	}
+	for _, tt := range tests {
+		if got := tt.v.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+	if b, err := Transport(7).MarshalText(); err != nil || string(b) != "Transport(7)" {
+		t.Errorf("Transport(7).MarshalText() = %q, %v, want Transport(7)", b, err)
+	}
+}
@@ -0,0 +1,112 @@
+package airsensor
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Parameters of the simulated signal.
+const (
+	// simBase and simDaily give the diurnal baseline: lowest at night,
+	// peaking in the afternoon when rooms are occupied.
+	simBase  = 650
+	simDaily = 150
+	// simWalkStep is the standard deviation of the random walk per
+	// minute; the walk reverts to the baseline with simWalkDecay.
+	simWalkStep  = 8
+	simWalkDecay = 30 * time.Minute
+	// A spike, like opening a bottle of cleaning agent, starts about
+	// every simSpikeEvery and fades with simSpikeDecay.
+	simSpikeEvery = 2 * time.Hour
+	simSpikeDecay = 5 * time.Minute
+	simSpikeMax   = 900
+)
+
+// SimulatedSensor is a SensorReader generating a believable VOC signal
+// without a stick attached: a diurnal baseline plus a random walk, with
+// occasional spikes. Unlike MockSensor, it runs for ever and is meant
+// for demos. Spikes may exceed MaxVOC, yielding invalid readings like a
+// saturated stick would.
+type SimulatedSensor struct {
+	// Calibration is applied to every reading to fill in
+	// Reading.Calibrated. It defaults to NoCalibration.
+	Calibration Calibration
+
+	serial string
+	mu     sync.Mutex
+	rnd    *rand.Rand
+	last   time.Time
+	walk   float64
+	spike  float64
+	closed bool
+}
+
+var _ SensorReader = (*SimulatedSensor)(nil)
+
+// SimulatedDevice is the device ID of the readings of a SimulatedSensor,
+// that of the 03eb:2013 stick it poses as.
+var SimulatedDevice = DeviceID{Vendor: VendorID, Product: ProductID}
+
+// NewSimulatedSensor returns a SimulatedSensor whose readings carry the
+// given serial. The same seed yields the same random walk and spikes.
+func NewSimulatedSensor(serial string, seed int64) *SimulatedSensor {
+	return &SimulatedSensor{
+		Calibration: NoCalibration,
+		serial:      serial,
+		rnd:         rand.New(rand.NewSource(seed)),
+	}
+}
+
+// ReadVOC generates a reading for the current time.
+func (s *SimulatedSensor) ReadVOC() (*Reading, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("simulated sensor: ReadVOC() called after Close")
+	}
+	now := time.Now()
+	voc := s.next(now)
+	r, err := parseReading(mockFrame(voc), now, DefaultParseConfig)
+	if err != nil {
+		return nil, err
+	}
+	r.Serial = s.serial
+	r.Device = SimulatedDevice
+	r.Calibrated = s.Calibration.Apply(r.VOC)
+	r.Smoothed = r.Calibrated
+	return r, nil
+}
+
+// next advances the signal to now and returns its value.
+func (s *SimulatedSensor) next(now time.Time) int16 {
+	dt := time.Minute
+	if !s.last.IsZero() {
+		dt = max(now.Sub(s.last), 0)
+	}
+	s.last = now
+
+	minutes := dt.Minutes()
+	s.walk = s.walk*math.Exp(-float64(dt)/float64(simWalkDecay)) +
+		s.rnd.NormFloat64()*simWalkStep*math.Sqrt(minutes)
+	s.spike *= math.Exp(-float64(dt) / float64(simSpikeDecay))
+	if s.rnd.Float64() < float64(dt)/float64(simSpikeEvery) {
+		s.spike += simSpikeMax * (0.3 + 0.7*s.rnd.Float64())
+	}
+
+	h := float64(now.Hour()) + float64(now.Minute())/60
+	// Lowest at 3am, highest at 3pm.
+	daily := -simDaily * math.Cos(2*math.Pi*(h-3)/24)
+	v := simBase + daily + s.walk + s.spike
+	return int16(math.Max(1, math.Min(v, math.MaxInt16)))
+}
+
+// Close stops the simulation.
+func (s *SimulatedSensor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
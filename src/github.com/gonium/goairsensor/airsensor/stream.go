@@ -0,0 +1,94 @@
+package airsensor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// StreamBuffer is the number of readings a Stream channel holds before
+// the oldest ones are dropped.
+const StreamBuffer = 16
+
+// Stream reads the sensor every interval, starting right away, and
+// delivers the readings on the returned channel, invalid ones included. A
+// failed read is logged and skipped, unless the device is gone or the
+// sensor was closed, which ends the stream; StreamErr returns that error.
+// The channel is also closed when ctx is cancelled. An interval that
+// isn't positive ends the stream before the first read, with StreamErr
+// reporting it.
+//
+// Reads never wait for the consumer: when the channel is full, the oldest
+// reading is dropped to make room for the new one.
+func (s *Sensor) Stream(ctx context.Context, interval time.Duration) <-chan Reading {
+	ch := make(chan Reading, StreamBuffer)
+	if interval <= 0 {
+		s.setStreamErr(fmt.Errorf("stream interval %s not positive", interval))
+		close(ch)
+		return ch
+	}
+	s.setStreamErr(nil)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			r, err := s.ReadVOCContext(ctx)
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err != nil && (IsDisconnected(err) || s.isClosed()):
+				s.setStreamErr(err)
+				return
+			case err != nil:
+				slog.Warn("Reading sensor failed", "serial", s.info.ID(), "err", err)
+			default:
+				send(ch, *r)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return ch
+}
+
+// send delivers r on ch, dropping the oldest reading if ch is full. It
+// must only be called by the single sender of ch.
+func send(ch chan Reading, r Reading) {
+	for {
+		select {
+		case ch <- r:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// StreamErr returns the error that ended the last Stream, or nil if it is
+// still running or ended because its context was cancelled.
+func (s *Sensor) StreamErr() error {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	return s.streamErr
+}
+
+func (s *Sensor) setStreamErr(err error) {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	s.streamErr = err
+}
+
+// isClosed reports whether Close has been called.
+func (s *Sensor) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,23 @@
+package airsensor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStreamNonPositiveInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		ep := newFakeEndpoint(mockFrame(800))
+		s := newFakeSensor(ep)
+		for r := range s.Stream(context.Background(), interval) {
+			t.Errorf("Stream(%s) delivered %+v, want no readings", interval, r)
+		}
+		if s.StreamErr() == nil {
+			t.Errorf("StreamErr() after Stream(%s) = nil, want an error", interval)
+		}
+		if n := len(ep.written()); n != 0 {
+			t.Errorf("Stream(%s) wrote %d requests, want none", interval, n)
+		}
+	}
+}
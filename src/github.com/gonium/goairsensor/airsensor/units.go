@@ -0,0 +1,32 @@
+package airsensor
+
+// The iAQ-2000 module in the stick reports TVOC equivalents between
+// MinTVOCppb and MaxTVOCppb for CO2 equivalents between MinVOC and
+// MaxVOC. The data sheet gives no exact relation, so the conversion
+// assumes the two ranges map linearly onto each other.
+const (
+	MinTVOCppb = 125
+	MaxTVOCppb = 600
+)
+
+// TVOC equivalents are given as isobutylene. Converting ppb to mg/m³ uses
+// its molar mass and the molar volume of an ideal gas at 25°C and
+// 1013.25 hPa.
+const (
+	isobutyleneMolarMass = 56.1  // g/mol
+	molarVolume          = 24.45 // l/mol
+)
+
+// CO2Equivalent returns the VOC concentration in ppm CO2-equivalent, which
+// is what the sensor reports.
+func (r *Reading) CO2Equivalent() int16 {
+	return r.VOC
+}
+
+// TVOCmgm3 returns an estimate of the total VOC concentration in mg/m³
+// isobutylene-equivalent. It is an approximation derived from the
+// data sheet ranges, not a calibrated measurement.
+func (r *Reading) TVOCmgm3() float64 {
+	ppb := MinTVOCppb + (float64(r.VOC)-MinVOC)*(MaxTVOCppb-MinTVOCppb)/(MaxVOC-MinVOC)
+	return ppb * isobutyleneMolarMass / molarVolume / 1000
+}
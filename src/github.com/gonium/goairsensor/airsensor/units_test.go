@@ -0,0 +1,25 @@
+package airsensor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTVOCmgm3(t *testing.T) {
+	mgm3 := func(ppb float64) float64 { return ppb * isobutyleneMolarMass / molarVolume / 1000 }
+	for _, tt := range []struct {
+		voc  int16
+		want float64
+	}{
+		{MinVOC, mgm3(MinTVOCppb)},
+		{MaxVOC, mgm3(MaxTVOCppb)},
+		{(MinVOC + MaxVOC) / 2, mgm3((MinTVOCppb + MaxTVOCppb) / 2.0)},
+		// Far below MinVOC, where VOC-MinVOC would wrap around in int16.
+		{math.MinInt16, mgm3(MinTVOCppb + (math.MinInt16-MinVOC)*(MaxTVOCppb-MinTVOCppb)/float64(MaxVOC-MinVOC))},
+	} {
+		r := &Reading{VOC: tt.voc}
+		if got := r.TVOCmgm3(); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("TVOCmgm3() of %d ppm = %v, want %v", tt.voc, got, tt.want)
+		}
+	}
+}
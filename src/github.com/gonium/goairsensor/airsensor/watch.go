@@ -0,0 +1,124 @@
+package airsensor
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/gousb"
+	"log/slog"
+	"time"
+)
+
+// A DeviceEvent reports a stick being plugged in or unplugged.
+type DeviceEvent struct {
+	Info SensorInfo
+	// Arrived is true for a stick plugged in and false for one
+	// unplugged.
+	Arrived bool
+}
+
+// Watch reports sticks accepted by all selectors as they are plugged in
+// and unplugged, until ctx is cancelled. The sticks connected when Watch
+// is called are reported as arrived first. Sticks are told apart by their
+// ID, see SensorInfo.ID.
+//
+// gousb doesn't expose the hotplug callbacks of libusb, so Watch lists
+// the device descriptors every interval, like Descriptors, which costs an
+// enumeration but doesn't open the sticks. Only a stick showing up at a
+// new bus and address is opened, once, to read its serial number; if that
+// fails, it is tried again on the next interval. A failed enumeration is
+// logged and reports nothing, so a flaky bus doesn't look like all sticks
+// being unplugged.
+func Watch(ctx context.Context, interval time.Duration, sel ...Selector) <-chan DeviceEvent {
+	ch := make(chan DeviceEvent)
+	go func() {
+		defer close(ch)
+		// seen holds the sticks described so far by bus and address,
+		// including those not accepted by sel, so they aren't opened
+		// again.
+		seen := make(map[busAddress]watched)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			descs, err := Descriptors()
+			if err != nil {
+				slog.Warn("Enumerating sticks failed", "err", err)
+			} else {
+				var events []DeviceEvent
+				present := make(map[busAddress]bool)
+				for _, desc := range descs {
+					at := busAddress{desc.Bus, desc.Address}
+					present[at] = true
+					if _, ok := seen[at]; ok {
+						continue
+					}
+					info, err := describe(at)
+					if err != nil {
+						slog.Warn("Describing stick failed", "bus", at.bus, "address", at.address, "err", err)
+						continue
+					}
+					w := watched{info: info, accepted: matches(info, sel)}
+					seen[at] = w
+					if w.accepted {
+						events = append(events, DeviceEvent{Info: info, Arrived: true})
+					}
+				}
+				for at, w := range seen {
+					if !present[at] {
+						delete(seen, at)
+						if w.accepted {
+							events = append(events, DeviceEvent{Info: w.info})
+						}
+					}
+				}
+				for _, ev := range events {
+					select {
+					case ch <- ev:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return ch
+}
+
+// busAddress is where a device sits on the bus. It stays the same until
+// the device is unplugged.
+type busAddress struct {
+	bus, address int
+}
+
+// watched is a stick seen by Watch, and whether its selectors accept it.
+type watched struct {
+	info     SensorInfo
+	accepted bool
+}
+
+// describe opens the stick at at just long enough to describe it, see
+// Discover.
+func describe(at busAddress) (SensorInfo, error) {
+	ctx := gousb.NewContext()
+	defer ctx.Close()
+
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return desc.Bus == at.bus && desc.Address == at.address && SupportedDevices.match(desc)
+	})
+	var infos []SensorInfo
+	for _, dev := range devs {
+		infos = append(infos, infoOf(dev))
+		dev.Close()
+	}
+	if len(infos) == 0 {
+		if err == nil {
+			err = fmt.Errorf("%w: no stick at bus %d, address %d", ErrDeviceNotFound, at.bus, at.address)
+		}
+		return SensorInfo{}, err
+	}
+	return infos[0], nil
+}
@@ -0,0 +1,130 @@
+// airsensor_exporter polls iAQ-Sticks and exports their readings as
+// Prometheus metrics, and nothing else. It is a small alternative to
+// airsensor_httpd for nodes that are only scraped.
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/gonium/goairsensor/airsensor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+var (
+	listen       = flag.String("listen", ":9425", "Address on which to serve /metrics")
+	interval     = flag.Duration("interval", 30*time.Second, "Poll the sticks at this interval")
+	device       = flag.String("device", airsensor.SupportedDevices.String(), "Comma-separated USB vendor:product IDs of the sticks to accept")
+	serial       = flag.String("serial", "", "Only use the stick with this USB serial number")
+	detach       = flag.Bool("detach", runtime.GOOS == "linux", "Detach a kernel driver holding the stick (Linux only)")
+	openAttempts = flag.Int("open-attempts", 0, "Give up opening the sticks after this many attempts (0 retries forever)")
+	openDelay    = flag.Duration("open-delay", time.Second, "Initial delay between attempts to open the sticks")
+	logLevel     = flag.String("log-level", "info", "Log level, one of debug, info, warn or error")
+)
+
+// The metrics share their names and the serial label with airsensor_httpd,
+// so queries work with either.
+var (
+	vocGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "airsensor_voc_ppm",
+		Unit: "ppm",
+		Help: "Last valid VOC concentration in ppm CO2-equivalent.",
+	}, []string{"serial"})
+	readErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airsensor_read_errors_total",
+		Help: "Number of failed or out-of-range sensor reads by reason.",
+	}, []string{"serial", "reason"})
+)
+
+// poll reads s every interval until ctx is done. The priming reading
+// after (re)opening the stick is skipped.
+func poll(ctx context.Context, serial string, s airsensor.SensorReader) {
+	t := time.NewTicker(*interval)
+	defer t.Stop()
+	for {
+		r, err := s.ReadVOC()
+		if err == nil {
+			err = r.Err()
+		}
+		switch {
+		case err == nil:
+			vocGauge.WithLabelValues(serial).Set(float64(r.VOC))
+		case r != nil && r.Priming:
+			slog.Debug("Discarding priming reading", "serial", serial, "voc", r.VOC)
+		default:
+			slog.Warn("Reading sensor failed", "serial", serial, "err", err)
+			readErrors.WithLabelValues(serial, airsensor.Reason(err)).Inc()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func main() {
+	flag.Parse()
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(*logLevel)); err != nil {
+		fatal("Invalid -log-level", "log_level", *logLevel)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: lvl})))
+	if *interval <= 0 {
+		fatal("Invalid -interval, must be positive", "interval", *interval)
+	}
+	var err error
+	if airsensor.SupportedDevices, err = airsensor.ParseDeviceIDs(*device); err != nil {
+		fatal("Invalid -device", "err", err)
+	}
+	var sel []airsensor.Selector
+	if *serial != "" {
+		sel = append(sel, airsensor.BySerial(*serial))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts := airsensor.Options{AutoDetach: *detach}
+	sensors, err := opts.OpenAllWithRetry(ctx, *openAttempts, *openDelay, sel...)
+	if err != nil {
+		fatal("Could not open sensor", "err", err)
+	}
+	for _, s := range sensors {
+		id := s.Info().ID()
+		reopen := append([]airsensor.Selector{airsensor.ByID(id)}, sel...)
+		rs := airsensor.NewReconnecting(s, func() (airsensor.SensorReader, error) {
+			return opts.Open(reopen...)
+		}, *openDelay)
+		defer rs.Close()
+		slog.Info("Opened sensor", "serial", id)
+		go poll(ctx, id, rs)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(vocGauge, readErrors)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	hs := &http.Server{Addr: *listen, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		hs.Shutdown(sctx)
+	}()
+	slog.Info("Listening", "addr", *listen)
+	if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("HTTP server failed", "err", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// band is an air quality level as delimited by the thresholds.
+type band int
+
+const (
+	bandOK band = iota
+	bandWarn
+	bandCrit
+)
+
+func (b band) String() string {
+	return [...]string{"ok", "warn", "crit"}[b]
+}
+
+// Types of alerts.
+const (
+	alertBand    = "band"
+	alertAnomaly = "anomaly"
+)
+
+// alert is the body POSTed to the webhook. Band alerts carry the smoothed
+// value, anomaly alerts the calibrated value of the spike and the current
+// band, but no direction or previous band.
+type alert struct {
+	Type      string   `json:"type"`
+	Direction string   `json:"direction,omitempty"`
+	Band      string   `json:"band"`
+	Previous  string   `json:"previous,omitempty"`
+	Value     float64  `json:"value"`
+	VOC       int16    `json:"voc_ppm"`
+	Serial    string   `json:"serial"`
+	Timestamp jsonTime `json:"ts"`
+}
+
+// alerter POSTs an alert to a webhook whenever the smoothed VOC value of a
+// stick moves into another band. A value has to drop hysteresis ppm below
+// a threshold to leave its band downwards, so a value hovering around a
+// threshold doesn't flap. With anomalies, it also POSTs one when the
+// readings of a stick turn anomalous.
+type alerter struct {
+	warn, crit float64
+	hysteresis float64
+	anomalies  bool
+	url        string
+	client     *http.Client
+
+	mu        sync.Mutex
+	bands     map[string]band
+	anomalous map[string]bool
+}
+
+func newAlerter(url string, warn, crit, hysteresis float64, anomalies bool) *alerter {
+	return &alerter{
+		url:        url,
+		warn:       warn,
+		crit:       crit,
+		hysteresis: hysteresis,
+		anomalies:  anomalies,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		bands:      make(map[string]band),
+		anomalous:  make(map[string]bool),
+	}
+}
+
+// threshold returns the value at which band b starts.
+func (a *alerter) threshold(b band) float64 {
+	if b == bandCrit {
+		return a.crit
+	}
+	return a.warn
+}
+
+// next returns the band of v given the current band cur.
+func (a *alerter) next(cur band, v float64) band {
+	b := cur
+	for b < bandCrit && v >= a.threshold(b+1) {
+		b++
+	}
+	for b > bandOK && v < a.threshold(b)-a.hysteresis {
+		b--
+	}
+	return b
+}
+
+// Write fires the webhook when a valid reading changes the band. Readings
+// during warm-up are ignored. The webhook is called in the background, so
+// a slow receiver doesn't hold up the poll loop; failures are logged.
+func (a *alerter) Write(_ context.Context, r *airsensor.Reading) error {
+	if !r.Valid || r.Stabilizing {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.anomalies {
+		a.checkAnomaly(r)
+	}
+	cur := a.bands[r.Serial]
+	b := a.next(cur, r.Smoothed)
+	if b == cur {
+		return nil
+	}
+	al := alert{
+		Type:      alertBand,
+		Direction: "rising",
+		Band:      b.String(),
+		Previous:  cur.String(),
+		Value:     r.Smoothed,
+		VOC:       r.VOC,
+		Serial:    r.Serial,
+		Timestamp: jsonTime(r.Timestamp),
+	}
+	if b < cur {
+		al.Direction = "falling"
+	}
+	a.bands[r.Serial] = b
+	slog.Info("Air quality changed", "serial", al.Serial, "band", al.Band, "previous", al.Previous, "value", al.Value)
+	go a.send(al)
+	return nil
+}
+
+// checkAnomaly fires the webhook when r is anomalous and the previous
+// reading of the stick wasn't, so a spike spanning several readings fires
+// once.
+func (a *alerter) checkAnomaly(r *airsensor.Reading) {
+	was := a.anomalous[r.Serial]
+	a.anomalous[r.Serial] = r.Anomalous
+	if !r.Anomalous || was {
+		return
+	}
+	al := alert{
+		Type:      alertAnomaly,
+		Band:      a.bands[r.Serial].String(),
+		Value:     r.Calibrated,
+		VOC:       r.VOC,
+		Serial:    r.Serial,
+		Timestamp: jsonTime(r.Timestamp),
+	}
+	slog.Info("Anomalous reading", "serial", al.Serial, "value", al.Value)
+	go a.send(al)
+}
+
+func (a *alerter) Close() error {
+	return nil
+}
+
+func (a *alerter) send(al alert) {
+	body, err := json.Marshal(al)
+	if err != nil {
+		slog.Error("Failed to encode alert", "err", err)
+		return
+	}
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Failed to send alert", "url", a.url, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		slog.Error("Failed to send alert", "url", a.url, "status", resp.Status)
+	}
+}
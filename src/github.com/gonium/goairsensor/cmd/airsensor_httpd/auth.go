@@ -0,0 +1,26 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// withBearerAuth rejects requests to h that don't carry
+// "Authorization: Bearer <token>". /healthz and /readyz stay open for
+// probes.
+func withBearerAuth(h http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="airsensor"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "unauthorized", nil)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Failed writes of a bufferedSink are retried with exponential backoff,
+// from sinkRetryDelay up to sinkMaxRetryDelay. A single write is given up
+// after sinkWriteTimeout.
+const (
+	sinkRetryDelay    = time.Second
+	sinkMaxRetryDelay = time.Minute
+	sinkWriteTimeout  = 10 * time.Second
+)
+
+// sinkQueue holds the readings not yet delivered to a sink, oldest first.
+// With max > 0 it holds at most max readings; the oldest are dropped to
+// make room for new ones. Its size and the dropped readings are exported
+// as metrics to mx.
+type sinkQueue struct {
+	name string
+	max  int
+	mx   *metrics
+
+	mu sync.Mutex
+	rs []*airsensor.Reading
+	// dropping is set while the queue is full, so an outage is logged
+	// once rather than for every reading dropped.
+	dropping bool
+}
+
+func newSinkQueue(name string, max int, mx *metrics) *sinkQueue {
+	return &sinkQueue{name: name, max: max, mx: mx}
+}
+
+// push appends r.
+func (q *sinkQueue) push(r *airsensor.Reading) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rs = append(q.rs, r)
+	q.trim()
+}
+
+// requeue puts rs back in front of the queued readings, after delivering
+// them failed.
+func (q *sinkQueue) requeue(rs []*airsensor.Reading) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rs = append(rs[:len(rs):len(rs)], q.rs...)
+	q.trim()
+}
+
+// trim drops the oldest readings beyond max and updates the metrics. q.mu
+// must be held.
+func (q *sinkQueue) trim() {
+	if drop := len(q.rs) - q.max; q.max > 0 && drop > 0 {
+		if !q.dropping {
+			slog.Warn("Sink buffer full, dropping the oldest readings", "sink", q.name, "size", q.max)
+			q.dropping = true
+		}
+		q.mx.sinkDropped.WithLabelValues(q.name).Add(float64(drop))
+		q.rs = append([]*airsensor.Reading(nil), q.rs[drop:]...)
+	} else if len(q.rs) < q.max {
+		q.dropping = false
+	}
+	q.mx.sinkBuffered.WithLabelValues(q.name).Set(float64(len(q.rs)))
+}
+
+// take removes and returns all queued readings.
+func (q *sinkQueue) take() []*airsensor.Reading {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	rs := q.rs
+	q.rs = nil
+	q.trim()
+	return rs
+}
+
+// front returns the oldest queued reading, if there is one.
+func (q *sinkQueue) front() (*airsensor.Reading, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.rs) == 0 {
+		return nil, false
+	}
+	return q.rs[0], true
+}
+
+// pop removes r, returned by front, unless it was dropped meanwhile.
+func (q *sinkQueue) pop(r *airsensor.Reading) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.rs) > 0 && q.rs[0] == r {
+		q.rs = q.rs[1:]
+		q.trim()
+	}
+}
+
+// len returns the number of queued readings.
+func (q *sinkQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.rs)
+}
+
+// bufferedSink delivers readings to a sink in the background, so a sink
+// that is briefly down, like an unreachable MQTT broker, loses no
+// readings. They are queued and written in order; a failed write is
+// retried with exponential backoff until it succeeds. Only readings
+// pushed out of the full queue are lost.
+type bufferedSink struct {
+	Sink
+	q *sinkQueue
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newBufferedSink(name string, s Sink, size int, mx *metrics) *bufferedSink {
+	b := &bufferedSink{
+		Sink: s,
+		q:    newSinkQueue(name, size, mx),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Write queues r for delivery. It never fails; failed deliveries are
+// logged when they are retried.
+func (b *bufferedSink) Write(_ context.Context, r *airsensor.Reading) error {
+	b.q.push(r)
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run delivers the queued readings until stopped. What is left is up to
+// drain.
+func (b *bufferedSink) run() {
+	defer close(b.done)
+	failures := 0
+	for {
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+		r, ok := b.q.front()
+		if !ok {
+			select {
+			case <-b.wake:
+				continue
+			case <-b.stop:
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sinkWriteTimeout)
+		err := b.Sink.Write(ctx, r)
+		cancel()
+		if err == nil {
+			b.q.pop(r)
+			failures = 0
+			continue
+		}
+		failures++
+		delay := retryDelay(failures)
+		slog.Warn("Writing reading failed, retrying", "sink", b.q.name, "serial", r.Serial, "buffered", b.q.len(), "retry_in", delay, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// retryDelay returns the delay before retrying after the given number of
+// failures in a row.
+func retryDelay(failures int) time.Duration {
+	if failures >= 7 {
+		return sinkMaxRetryDelay
+	}
+	return min(sinkRetryDelay<<(failures-1), sinkMaxRetryDelay)
+}
+
+// halt stops the background delivery and waits for it to end.
+func (b *bufferedSink) halt() {
+	b.stopOnce.Do(func() { close(b.stop) })
+	<-b.done
+}
+
+// drain stops the background delivery and delivers the queued readings
+// until all are out or ctx is done, retrying failed writes.
+func (b *bufferedSink) drain(ctx context.Context) (flushed, left int) {
+	b.halt()
+	failures := 0
+	for {
+		r, ok := b.q.front()
+		if !ok {
+			return flushed, 0
+		}
+		wctx, cancel := context.WithTimeout(ctx, sinkWriteTimeout)
+		err := b.Sink.Write(wctx, r)
+		cancel()
+		if err == nil {
+			b.q.pop(r)
+			flushed++
+			failures = 0
+			continue
+		}
+		failures++
+		slog.Warn("Writing reading failed while draining", "sink", b.q.name, "serial", r.Serial, "buffered", b.q.len(), "err", err)
+		select {
+		case <-time.After(retryDelay(failures)):
+		case <-ctx.Done():
+			return flushed, b.q.len()
+		}
+	}
+}
+
+// Close drops the readings drain didn't deliver and closes the sink.
+func (b *bufferedSink) Close() error {
+	b.halt()
+	dropQueued(b.q)
+	return b.Sink.Close()
+}
+
+// dropQueued empties q, logging and counting the readings dropped.
+func dropQueued(q *sinkQueue) {
+	if rs := q.take(); len(rs) > 0 {
+		slog.Warn("Dropping undelivered readings", "sink", q.name, "count", len(rs))
+		q.mx.sinkDropped.WithLabelValues(q.name).Add(float64(len(rs)))
+	}
+}
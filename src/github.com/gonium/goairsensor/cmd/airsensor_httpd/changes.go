@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"github.com/gonium/goairsensor/airsensor"
+	"math"
+	"sync"
+	"time"
+)
+
+// changesOnly passes a reading on to its sink only if it differs from the
+// last one passed on for the same stick: its VOC value moved by more than
+// delta or its validity changed. With maxGap > 0 a reading is passed on at
+// least every maxGap regardless, so flat stretches still leave a trace.
+type changesOnly struct {
+	Sink
+	delta  float64
+	maxGap time.Duration
+
+	mu   sync.Mutex
+	last map[string]*airsensor.Reading
+}
+
+func newChangesOnly(s Sink, delta float64, maxGap time.Duration) *changesOnly {
+	return &changesOnly{
+		Sink:   s,
+		delta:  delta,
+		maxGap: maxGap,
+		last:   make(map[string]*airsensor.Reading),
+	}
+}
+
+// changed reports whether r is to be passed on and remembers it if so.
+func (c *changesOnly) changed(r *airsensor.Reading) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last, ok := c.last[r.Serial]
+	switch {
+	case !ok, r.Valid != last.Valid,
+		math.Abs(float64(r.VOC)-float64(last.VOC)) > c.delta,
+		c.maxGap > 0 && r.Sub(last) >= c.maxGap:
+		c.last[r.Serial] = r
+		return true
+	}
+	return false
+}
+
+// drain drains the wrapped sink, if it holds readings.
+func (c *changesOnly) drain(ctx context.Context) (flushed, left int) {
+	if d, ok := c.Sink.(drainer); ok {
+		return d.drain(ctx)
+	}
+	return 0, 0
+}
+
+func (c *changesOnly) Write(ctx context.Context, r *airsensor.Reading) error {
+	if !c.changed(r) {
+		return nil
+	}
+	return c.Sink.Write(ctx, r)
+}
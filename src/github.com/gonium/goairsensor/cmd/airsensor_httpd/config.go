@@ -0,0 +1,410 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of the daemon. Each field is set by the flag
+// named like its yaml key, by the matching environment variable, or by
+// that key in the file given with -config-file.
+type Config struct {
+	// File is the YAML file the settings were loaded from.
+	File string `yaml:"-"`
+
+	Device      string              `yaml:"device"`
+	Names       nameMap             `yaml:"names"`
+	Virtual     virtualMap          `yaml:"virtual"`
+	USBConfig   int                 `yaml:"config"`
+	Interface   int                 `yaml:"interface"`
+	Setup       int                 `yaml:"setup"`
+	Endpoint    int                 `yaml:"endpoint"`
+	OutEndpoint int                 `yaml:"out-endpoint"`
+	Transport   airsensor.Transport `yaml:"transport"`
+	Debug       int                 `yaml:"debug"`
+	Serial      string              `yaml:"serial"`
+	Bus         int                 `yaml:"bus"`
+	Address     int                 `yaml:"address"`
+	Detach      bool                `yaml:"detach"`
+	Simulate    bool                `yaml:"simulate"`
+	Hotplug     time.Duration       `yaml:"hotplug"`
+
+	Listen     string `yaml:"listen"`
+	SocketMode string `yaml:"socket-mode"`
+	MDNS       bool   `yaml:"mdns"`
+	Gzip       bool   `yaml:"gzip"`
+
+	TLSCert    string `yaml:"tls-cert"`
+	TLSKey     string `yaml:"tls-key"`
+	ACMEDomain string `yaml:"acme-domain"`
+	ACMECache  string `yaml:"acme-cache"`
+	AuthToken  string `yaml:"auth-token"`
+
+	Interval       time.Duration `yaml:"interval"`
+	IntervalJitter float64       `yaml:"interval-jitter"`
+	EMAAlpha       float64       `yaml:"ema-alpha"`
+	StatsSize      int           `yaml:"stats-size"`
+
+	CalOffset float64 `yaml:"cal-offset"`
+	CalScale  float64 `yaml:"cal-scale"`
+
+	PreRequestReads int           `yaml:"pre-request-reads"`
+	Flush           bool          `yaml:"flush"`
+	FlushTimeout    time.Duration `yaml:"flush-timeout"`
+
+	FrameSize         int    `yaml:"frame-size"`
+	TemperatureOffset int    `yaml:"temperature-offset"`
+	VOCOffset         int    `yaml:"voc-offset"`
+	VOCEndian         string `yaml:"voc-endian"`
+
+	LogFormat       string `yaml:"log-format"`
+	LogLevel        string `yaml:"log-level"`
+	TimestampFormat string `yaml:"timestamp-format"`
+
+	ReadTimeout     time.Duration `yaml:"read-timeout"`
+	WriteTimeout    time.Duration `yaml:"write-timeout"`
+	OpenAttempts    int           `yaml:"open-attempts"`
+	OpenDelay       time.Duration `yaml:"open-delay"`
+	MinReadInterval time.Duration `yaml:"min-read-interval"`
+	Warmup          time.Duration `yaml:"warmup"`
+	HoldLastValid   bool          `yaml:"hold-last-valid"`
+
+	ResetAfter       int           `yaml:"reset-after"`
+	MinResetInterval time.Duration `yaml:"min-reset-interval"`
+
+	BreakerThreshold int           `yaml:"breaker-threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker-cooldown"`
+
+	MQTTBroker   string `yaml:"mqtt-broker"`
+	MQTTTopic    string `yaml:"mqtt-topic"`
+	MQTTQoS      int    `yaml:"mqtt-qos"`
+	MQTTUsername string `yaml:"mqtt-username"`
+	MQTTPassword string `yaml:"mqtt-password"`
+
+	InfluxStdout bool          `yaml:"influx-stdout"`
+	InfluxURL    string        `yaml:"influx-url"`
+	InfluxDB     string        `yaml:"influx-db"`
+	InfluxFlush  time.Duration `yaml:"influx-flush"`
+
+	PushURL          string `yaml:"push-url"`
+	HistogramBuckets string `yaml:"histogram-buckets"`
+
+	SinkBuffer   int           `yaml:"sink-buffer"`
+	DrainTimeout time.Duration `yaml:"drain-timeout"`
+
+	ChangesOnly bool          `yaml:"changes-only"`
+	ChangeDelta float64       `yaml:"change-delta"`
+	MaxGap      time.Duration `yaml:"max-gap"`
+
+	CSV          string        `yaml:"csv"`
+	SQLite       string        `yaml:"sqlite"`
+	RawRetention time.Duration `yaml:"raw-retention"`
+	StateFile    string        `yaml:"state-file"`
+
+	Dump     bool   `yaml:"dump"`
+	DumpFile string `yaml:"dump-file"`
+
+	WarnThreshold    int           `yaml:"warn-threshold"`
+	CritThreshold    int           `yaml:"crit-threshold"`
+	AlertHysteresis  float64       `yaml:"alert-hysteresis"`
+	WebhookURL       string        `yaml:"webhook-url"`
+	AnomalySigma     float64       `yaml:"anomaly-sigma"`
+	AnomalyWindow    int           `yaml:"anomaly-window"`
+	AnomalyWebhook   bool          `yaml:"anomaly-webhook"`
+	BaselineHalfLife time.Duration `yaml:"baseline-half-life"`
+	DashboardMinutes int           `yaml:"dashboard-minutes"`
+
+	StaleAfter time.Duration `yaml:"stale-after"`
+
+	Pprof       bool   `yaml:"pprof"`
+	PprofListen string `yaml:"pprof-listen"`
+}
+
+// registerFlags defines a flag for every field of c in fs, with the
+// defaults of the daemon.
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.File, fileFlag, "", "YAML file with settings; the environment and flags given on the command line take precedence")
+
+	fs.StringVar(&c.Device, "device", airsensor.SupportedDevices.String(), "Comma-separated USB vendor:product IDs of the sticks to accept")
+	fs.Var(&c.Names, "names", "Friendly names of the sticks as comma-separated serial=name pairs, e.g. 1234=office; a bus:address pair stands in for a missing serial")
+	fs.Var(&c.Virtual, "virtual", "Virtual sensors combining sticks as comma-separated name=[mean|median:]device+device entries, e.g. room=1234+5678; devices are serials or -names")
+	fs.IntVar(&c.USBConfig, "config", 1, "USB configuration to use (0 keeps the active one)")
+	fs.IntVar(&c.Interface, "interface", 0, "USB interface to claim")
+	fs.IntVar(&c.Setup, "setup", 0, "Alternate setting of -interface")
+	fs.IntVar(&c.Endpoint, "endpoint", airsensor.DefaultInEndpoint, "IN endpoint to read responses from")
+	fs.IntVar(&c.OutEndpoint, "out-endpoint", airsensor.DefaultOutEndpoint, "OUT endpoint to write requests to")
+	fs.TextVar(&c.Transport, "transport", airsensor.TransportAuto, "Exchange requests and responses over the endpoints, the control endpoint, or auto to fall back to control if the endpoints don't answer")
+	fs.IntVar(&c.Debug, "debug", 3, "Debug level for libusb")
+	fs.StringVar(&c.Serial, "serial", "", "Only use the stick with this USB serial number")
+	fs.IntVar(&c.Bus, "bus", 0, "Only use the sensor on this USB bus (requires -address)")
+	fs.IntVar(&c.Address, "address", 0, "Only use the sensor at this USB address (requires -bus)")
+	fs.BoolVar(&c.Detach, "detach", runtime.GOOS == "linux", "Detach a kernel driver holding the stick (Linux only)")
+	fs.BoolVar(&c.Simulate, "simulate", false, "Serve a simulated VOC signal instead of reading a stick")
+	fs.DurationVar(&c.Hotplug, "hotplug", 0, "Look for sticks plugged in or unplugged at this interval and add or remove them (0 only reconnects the sticks found at start)")
+
+	fs.StringVar(&c.Listen, "listen", ":8080", "Address on which to serve HTTP requests, or unix:/path for a Unix domain socket")
+	fs.StringVar(&c.SocketMode, "socket-mode", "0660", "Octal permissions of the -listen Unix domain socket")
+	fs.BoolVar(&c.MDNS, "mdns", false, "Advertise the HTTP service over mDNS as "+mdnsService)
+	fs.BoolVar(&c.Gzip, "gzip", false, "Compress HTTP responses for clients accepting gzip")
+
+	fs.StringVar(&c.TLSCert, "tls-cert", "", "Serve HTTPS with this certificate file (requires -tls-key)")
+	fs.StringVar(&c.TLSKey, "tls-key", "", "Private key file of -tls-cert")
+	fs.StringVar(&c.ACMEDomain, "acme-domain", "", "Serve HTTPS for this domain with a certificate from Let's Encrypt")
+	fs.StringVar(&c.ACMECache, "acme-cache", "autocert-cache", "Directory to cache -acme-domain certificates in")
+	fs.StringVar(&c.AuthToken, "auth-token", "", "Require this bearer token on all requests but /healthz and /readyz")
+
+	fs.DurationVar(&c.Interval, "interval", 0, "Poll the sensor at this interval (0 reads only on request)")
+	fs.Float64Var(&c.IntervalJitter, "interval-jitter", 0, "Shift each poll randomly by up to this fraction of -interval, e.g. 0.1 for ±10%")
+	fs.Float64Var(&c.EMAAlpha, "ema-alpha", 0, "Smoothing factor of the moving average in (0, 1], 0 disables smoothing")
+	fs.IntVar(&c.StatsSize, "stats-size", 3600, "Number of recent readings kept per stick for /stats and the valid ratio")
+
+	fs.Float64Var(&c.CalOffset, "cal-offset", 0, "Calibration offset in ppm added to every scaled reading")
+	fs.Float64Var(&c.CalScale, "cal-scale", 1, "Calibration factor every reading is multiplied with")
+	fs.IntVar(&c.PreRequestReads, "pre-request-reads", 1, "Reads discarding stale bytes before every request; -1 reads until nothing is pending")
+	fs.BoolVar(&c.Flush, "flush", true, "Read a trailing frame after every response, which some sticks send")
+	fs.DurationVar(&c.FlushTimeout, "flush-timeout", airsensor.DefaultFlushTimeout, "Timeout of the -flush read, so sticks sending no trailing frame don't stall every read")
+	fs.IntVar(&c.FrameSize, "frame-size", airsensor.FrameSize, "Size of a response frame in bytes, for firmware returning longer frames")
+	fs.IntVar(&c.TemperatureOffset, "temperature-offset", 0, "Offset of the temperature byte in the response frame, if the firmware has one (experimental, 0 disables)")
+	fs.IntVar(&c.VOCOffset, "voc-offset", airsensor.DefaultParseConfig.VOCOffset, "Offset of the VOC value in the response frame, for firmware placing it elsewhere")
+	fs.StringVar(&c.VOCEndian, "voc-endian", "little", "Byte order of the VOC value in the response frame, little or big")
+
+	fs.StringVar(&c.LogFormat, "log-format", "text", "Log format, text or json")
+	fs.StringVar(&c.LogLevel, "log-level", "info", "Log level, one of debug, info, warn or error")
+	fs.StringVar(&c.TimestampFormat, "timestamp-format", string(formatRFC3339), "Format of timestamps in CSV, JSON and log output, one of rfc3339, unix or unixnano")
+
+	fs.DurationVar(&c.ReadTimeout, "read-timeout", airsensor.DefaultReadTimeout, "Timeout of a single USB read")
+	fs.DurationVar(&c.WriteTimeout, "write-timeout", airsensor.DefaultWriteTimeout, "Timeout of a single USB write")
+	fs.IntVar(&c.OpenAttempts, "open-attempts", 0, "Give up opening the sensor after this many attempts (0 retries forever)")
+	fs.DurationVar(&c.OpenDelay, "open-delay", time.Second, "Initial delay between attempts to open the sensor")
+	fs.DurationVar(&c.MinReadInterval, "min-read-interval", time.Second, "Minimum time between two sensor reads for /voc; requests in between get the last reading")
+	fs.DurationVar(&c.Warmup, "warmup", 0, "Mark readings as stabilizing for this long after the sensor is opened")
+	fs.BoolVar(&c.HoldLastValid, "hold-last-valid", false, "Serve and write the last valid reading in place of an out-of-range one")
+
+	fs.IntVar(&c.ResetAfter, "reset-after", 5, "Reset a stick after this many consecutive bad frames (0 disables)")
+	fs.DurationVar(&c.MinResetInterval, "min-reset-interval", airsensor.DefaultMinResetInterval, "Minimum time between two resets of a stick")
+
+	fs.IntVar(&c.BreakerThreshold, "breaker-threshold", 10, "Stop reading a stick for -breaker-cooldown after this many consecutive failed reads (0 disables)")
+	fs.DurationVar(&c.BreakerCooldown, "breaker-cooldown", time.Minute, "Time a stick isn't read after -breaker-threshold failed reads")
+
+	fs.StringVar(&c.MQTTBroker, "mqtt-broker", "", "MQTT broker to publish readings to, e.g. tcp://localhost:1883")
+	fs.StringVar(&c.MQTTTopic, "mqtt-topic", "airsensor/voc", "MQTT topic to publish readings on")
+	fs.IntVar(&c.MQTTQoS, "mqtt-qos", 0, "MQTT quality of service level (0, 1 or 2)")
+	fs.StringVar(&c.MQTTUsername, "mqtt-username", "", "User name for the MQTT broker")
+	fs.StringVar(&c.MQTTPassword, "mqtt-password", "", "Password for the MQTT broker; prefer setting "+envName("mqtt-password"))
+
+	fs.BoolVar(&c.InfluxStdout, "influx-stdout", false, "Print readings to stdout as InfluxDB line protocol")
+	fs.StringVar(&c.InfluxURL, "influx-url", "", "InfluxDB server to write readings to, e.g. http://localhost:8086")
+	fs.StringVar(&c.InfluxDB, "influx-db", "airsensor", "InfluxDB database to write readings to")
+	fs.DurationVar(&c.InfluxFlush, "influx-flush", time.Minute, "Interval at which batched readings are sent to InfluxDB")
+
+	fs.StringVar(&c.HistogramBuckets, "histogram-buckets", defaultHistogramBuckets, "Comma-separated upper bounds of the buckets of airsensor_voc_ppm_distribution in ppm")
+	fs.StringVar(&c.PushURL, "push-url", "", "Push the metrics to this Prometheus Pushgateway after every poll, e.g. http://pushgateway:9091")
+
+	fs.IntVar(&c.SinkBuffer, "sink-buffer", 10000, "Keep up to this many undelivered readings per output and retry them while MQTT, InfluxDB or the file outputs fail (0 drops them)")
+	fs.DurationVar(&c.DrainTimeout, "drain-timeout", 10*time.Second, "On shutdown, keep delivering the readings buffered for the outputs for up to this long (0 drops them)")
+
+	fs.BoolVar(&c.ChangesOnly, "changes-only", false, "Only write a reading to MQTT, InfluxDB, CSV, SQLite and stdout if it differs from the last one written")
+	fs.Float64Var(&c.ChangeDelta, "change-delta", 0, "Amount in ppm by which a reading has to differ to count as a change with -changes-only")
+	fs.DurationVar(&c.MaxGap, "max-gap", 10*time.Minute, "Write a reading at least this often with -changes-only (0 disables)")
+
+	fs.StringVar(&c.CSV, "csv", "", "Append readings to this CSV file, rotated daily")
+	fs.StringVar(&c.SQLite, "sqlite", "", "Store readings in this SQLite database and serve them at /history")
+	fs.DurationVar(&c.RawRetention, "raw-retention", 0, "Keep readings in -sqlite for this long, then average them per 5 minutes (0 keeps all)")
+	fs.StringVar(&c.StateFile, "state-file", "", "Save the last reading of each stick to this JSON file on shutdown and serve it as stale after a restart")
+
+	fs.BoolVar(&c.Dump, "dump", false, "Print the raw response frame of every reading")
+	fs.StringVar(&c.DumpFile, "dump-file", "", "Append dumped frames to this file instead of stdout")
+
+	fs.IntVar(&c.WarnThreshold, "warn-threshold", 1000, "VOC value in ppm at which air quality is considered poor")
+	fs.IntVar(&c.CritThreshold, "crit-threshold", 1500, "VOC value in ppm at which air quality is considered bad")
+	fs.Float64Var(&c.AlertHysteresis, "alert-hysteresis", 50, "Amount in ppm a value has to drop below a threshold before the alert clears")
+	fs.StringVar(&c.WebhookURL, "webhook-url", "", "URL to POST an alert to when air quality crosses a threshold")
+	fs.Float64Var(&c.AnomalySigma, "anomaly-sigma", 0, "Mark readings deviating by more than this many standard deviations from the mean of -anomaly-window as anomalous (0 disables)")
+	fs.IntVar(&c.AnomalyWindow, "anomaly-window", 30, "Number of previous readings the mean and standard deviation of -anomaly-sigma are taken over")
+	fs.BoolVar(&c.AnomalyWebhook, "anomaly-webhook", false, "Also POST an alert to -webhook-url when a stick's readings turn anomalous")
+	fs.DurationVar(&c.BaselineHalfLife, "baseline-half-life", 0, "Track a clean-air baseline rising towards higher values with this half-life and serve the excess over it (0 disables)")
+	fs.IntVar(&c.DashboardMinutes, "dashboard-minutes", 30, "Minutes of readings shown in the dashboard chart")
+
+	fs.DurationVar(&c.StaleAfter, "stale-after", 0, "Report not ready on /readyz when there was no valid reading for this long (default 3 intervals)")
+
+	fs.BoolVar(&c.Pprof, "pprof", false, "Serve the Go profiler at /debug/pprof/ on -pprof-listen")
+	fs.StringVar(&c.PprofListen, "pprof-listen", "localhost:6060", "Address on which to serve -pprof, separate from -listen")
+}
+
+// loadFile reads the YAML file at path into c. Keys missing from the file
+// leave their fields untouched, an empty file changes nothing. Unknown
+// keys are an error, so typos don't go unnoticed.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// envName returns the environment variable setting the flag name, e.g.
+// AIRSENSOR_MQTT_BROKER for -mqtt-broker.
+func envName(name string) string {
+	return "AIRSENSOR_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// fileFlag names the YAML file to load settings from.
+const fileFlag = "config-file"
+
+// parse parses the command line args of fs into c, after registerFlags.
+// Each setting is taken from the first of: the command line, its
+// environment variable (see envName), the YAML file named by -config-file,
+// and the default.
+func (c *Config) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Loading the file and the environment overwrites the parsed flags,
+	// so remember and reapply those set explicitly.
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	fromEnv := func(f *flag.Flag) error {
+		if _, ok := explicit[f.Name]; ok {
+			return nil
+		}
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return nil
+		}
+		if err := f.Value.Set(v); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", v, envName(f.Name), err)
+		}
+		return nil
+	}
+
+	// The file may be named in the environment, too.
+	file := fs.Lookup(fileFlag)
+	if err := fromEnv(file); err != nil {
+		return err
+	}
+	if c.File != "" {
+		if err := c.loadFile(c.File); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err == nil && f != file {
+			err = fromEnv(f)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactedSecret replaces secrets in the configuration served at /config.
+const redactedSecret = "REDACTED"
+
+// redacted returns a copy of c with its secrets replaced: the passwords
+// and tokens, the passwords in URLs, and everything after the host of the
+// Pushgateway and webhook URLs.
+func (c Config) redacted() Config {
+	for _, secret := range []*string{&c.AuthToken, &c.MQTTPassword} {
+		if *secret != "" {
+			*secret = redactedSecret
+		}
+	}
+	for _, u := range []*string{&c.MQTTBroker, &c.InfluxURL} {
+		*u = redactURL(*u)
+	}
+	for _, u := range []*string{&c.PushURL, &c.WebhookURL} {
+		*u = redactEndpoint(*u)
+	}
+	return c
+}
+
+// redactURL replaces the password in s, if it is a URL with one. An
+// unparsable value is replaced as a whole, as it might still hold one.
+func redactURL(s string) string {
+	if s == "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactedSecret
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedSecret)
+	}
+	return u.String()
+}
+
+// redactEndpoint is redactURL for URLs that may carry a secret anywhere
+// after the host, like the token in the path of a Slack webhook: only the
+// scheme, user name and host are kept. A value without a host is replaced
+// as a whole.
+func redactEndpoint(s string) string {
+	if s == "" {
+		return s
+	}
+	u, err := url.Parse(redactURL(s))
+	if err != nil || u.Host == "" {
+		return redactedSecret
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		u.Path, u.RawPath, u.RawQuery, u.Fragment = "/"+redactedSecret, "", "", ""
+	}
+	return u.String()
+}
+
+// settings returns the redacted settings of c by their YAML keys, as in
+// the -config-file, along with the name of that file.
+func (c Config) settings() (map[string]interface{}, error) {
+	b, err := yaml.Marshal(c.redacted())
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]interface{})
+	if err := yaml.Unmarshal(b, &settings); err != nil {
+		return nil, err
+	}
+	settings[fileFlag] = c.File
+	return settings, nil
+}
+
+// handleConfig serves the effective settings, merged from the command
+// line, the environment and the -config-file, with secrets redacted.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	settings, err := cfg.settings()
+	if err != nil {
+		slog.Error("Failed to encode settings", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "internal error", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
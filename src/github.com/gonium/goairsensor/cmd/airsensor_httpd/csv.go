@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// csvLogger appends every reading to a CSV file. A new file is started at
+// local midnight; the date is appended to the configured file name, so
+// readings.csv becomes readings-2017-06-01.csv.
+type csvLogger struct {
+	path string
+
+	mu  sync.Mutex
+	day string
+	f   *os.File
+	w   *csv.Writer
+}
+
+func newCSVLogger(path string) *csvLogger {
+	return &csvLogger{path: path}
+}
+
+// filename returns the name of the file for the given day.
+func (l *csvLogger) filename(day string) string {
+	ext := filepath.Ext(l.path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(l.path, ext), day, ext)
+}
+
+// rotate closes the current file and opens the one for day, writing the
+// header row if the file is new.
+func (l *csvLogger) rotate(day string) error {
+	l.closeFile()
+	name := l.filename(day)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.day, l.f, l.w = day, f, csv.NewWriter(f)
+	if fi.Size() == 0 {
+		return l.writeRecord([]string{"timestamp", "voc_ppm", "valid", "serial"})
+	}
+	return nil
+}
+
+// writeRecord writes a single row and flushes it to disk right away, so a
+// crash doesn't lose data.
+func (l *csvLogger) writeRecord(record []string) error {
+	if err := l.w.Write(record); err != nil {
+		return err
+	}
+	l.w.Flush()
+	return l.w.Error()
+}
+
+func (l *csvLogger) Write(_ context.Context, r *airsensor.Reading) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	day := r.Timestamp.Local().Format("2006-01-02")
+	if day != l.day {
+		if err := l.rotate(day); err != nil {
+			return fmt.Errorf("opening CSV file: %w", err)
+		}
+	}
+	err := l.writeRecord([]string{
+		formatTime(r.Timestamp, time.RFC3339),
+		strconv.Itoa(int(r.VOC)),
+		strconv.FormatBool(r.Valid),
+		r.Serial,
+	})
+	if err != nil {
+		return fmt.Errorf("writing to %s: %w", l.f.Name(), err)
+	}
+	return nil
+}
+
+func (l *csvLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closeFile()
+}
+
+func (l *csvLogger) closeFile() error {
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f, l.w, l.day = nil, nil, ""
+	return err
+}
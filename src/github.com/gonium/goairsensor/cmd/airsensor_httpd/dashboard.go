@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+//go:embed dashboard
+var dashboardFS embed.FS
+
+var dashboardTmpl = template.Must(template.ParseFS(dashboardFS, "dashboard/index.html"))
+
+type dashboardParams struct {
+	Warn    int
+	Crit    int
+	Minutes int
+}
+
+// dashboardHandler serves the dashboard page. The indicator turns amber at
+// warn and red at crit ppm; the chart covers the last minutes.
+func dashboardHandler(p dashboardParams) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			writeError(w, http.StatusNotFound, codeNotFound, "not found", map[string]interface{}{"path": r.URL.Path})
+			return
+		}
+		if !allowGet(w, r) {
+			return
+		}
+		var buf bytes.Buffer
+		if err := dashboardTmpl.Execute(&buf, p); err != nil {
+			slog.Error("Failed to render dashboard", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "internal error", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// debugLevel is the current libusb debug level, initially -debug. It is
+// applied to sticks when they are (re)opened.
+var debugLevel atomic.Int32
+
+// maxDebugLevel is the most verbose libusb debug level.
+const maxDebugLevel = 4
+
+type debugLevelResponse struct {
+	Level int `json:"level"`
+}
+
+// handleDebugLevel reports the libusb debug level on GET and changes it
+// for all sticks on POST with level=<0-4>, given as query or form value.
+func (m *SensorManager) handleDebugLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		v := r.FormValue("level")
+		level, err := strconv.Atoi(v)
+		if err != nil || level < 0 || level > maxDebugLevel {
+			writeInvalidParameter(w, "level", fmt.Sprintf("invalid level %q, must be 0 to %d", v, maxDebugLevel))
+			return
+		}
+		debugLevel.Store(int32(level))
+		m.setDebug(level)
+		slog.Info("Changed libusb debug level", "level", level)
+	default:
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+	writeJSON(w, http.StatusOK, debugLevelResponse{Level: int(debugLevel.Load())})
+}
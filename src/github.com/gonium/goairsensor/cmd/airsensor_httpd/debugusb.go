@@ -0,0 +1,153 @@
+package main
+
+import (
+	"github.com/gonium/goairsensor/airsensor"
+	"github.com/google/gousb"
+	"log/slog"
+	"net/http"
+	"sort"
+)
+
+type usbDevice struct {
+	Bus     int    `json:"bus"`
+	Address int    `json:"address"`
+	Speed   string `json:"speed"`
+	Spec    string `json:"usb_version"`
+	Version string `json:"device_version"`
+	// Firmware is the same as Version, see
+	// airsensor.Sensor.FirmwareVersion.
+	Firmware string      `json:"firmware"`
+	Vendor   string      `json:"vendor"`
+	Product  string      `json:"product"`
+	Class    string      `json:"class"`
+	Configs  []usbConfig `json:"configs"`
+	// Manufacturer and ProductName are the strings of the stick, left
+	// out if it has none.
+	Manufacturer string `json:"manufacturer,omitempty"`
+	ProductName  string `json:"product_name,omitempty"`
+}
+
+type usbConfig struct {
+	Number       int            `json:"number"`
+	SelfPowered  bool           `json:"self_powered"`
+	RemoteWakeup bool           `json:"remote_wakeup"`
+	MaxPowerMA   uint           `json:"max_power_ma"`
+	Interfaces   []usbInterface `json:"interfaces"`
+}
+
+type usbInterface struct {
+	Number      int             `json:"number"`
+	AltSettings []usbAltSetting `json:"alt_settings"`
+}
+
+type usbAltSetting struct {
+	Alternate int           `json:"alternate"`
+	Class     string        `json:"class"`
+	SubClass  string        `json:"subclass"`
+	Protocol  string        `json:"protocol"`
+	Endpoints []usbEndpoint `json:"endpoints"`
+}
+
+type usbEndpoint struct {
+	Address       string `json:"address"`
+	Number        int    `json:"number"`
+	Direction     string `json:"direction"`
+	TransferType  string `json:"transfer_type"`
+	MaxPacketSize int    `json:"max_packet_size"`
+	PollInterval  string `json:"poll_interval"`
+}
+
+// newUSBDevice converts a device descriptor into its JSON tree, with
+// configs and endpoints sorted by number and address.
+func newUSBDevice(desc *gousb.DeviceDesc) usbDevice {
+	d := usbDevice{
+		Bus:      desc.Bus,
+		Address:  desc.Address,
+		Speed:    desc.Speed.String(),
+		Spec:     desc.Spec.String(),
+		Version:  desc.Device.String(),
+		Firmware: desc.Device.String(),
+		Vendor:   desc.Vendor.String(),
+		Product:  desc.Product.String(),
+		Class:    desc.Class.String(),
+		Configs:  []usbConfig{},
+	}
+	for _, cfg := range desc.Configs {
+		c := usbConfig{
+			Number:       cfg.Number,
+			SelfPowered:  cfg.SelfPowered,
+			RemoteWakeup: cfg.RemoteWakeup,
+			MaxPowerMA:   uint(cfg.MaxPower),
+		}
+		for _, intf := range cfg.Interfaces {
+			i := usbInterface{Number: intf.Number}
+			for _, alt := range intf.AltSettings {
+				a := usbAltSetting{
+					Alternate: alt.Alternate,
+					Class:     alt.Class.String(),
+					SubClass:  alt.SubClass.String(),
+					Protocol:  alt.Protocol.String(),
+				}
+				for _, ep := range alt.Endpoints {
+					a.Endpoints = append(a.Endpoints, usbEndpoint{
+						Address:       ep.Address.String(),
+						Number:        ep.Number,
+						Direction:     ep.Direction.String(),
+						TransferType:  ep.TransferType.String(),
+						MaxPacketSize: ep.MaxPacketSize,
+						PollInterval:  ep.PollInterval.String(),
+					})
+				}
+				sort.Slice(a.Endpoints, func(i, j int) bool {
+					return a.Endpoints[i].Address < a.Endpoints[j].Address
+				})
+				i.AltSettings = append(i.AltSettings, a)
+			}
+			c.Interfaces = append(c.Interfaces, i)
+		}
+		d.Configs = append(d.Configs, c)
+	}
+	sort.Slice(d.Configs, func(i, j int) bool {
+		return d.Configs[i].Number < d.Configs[j].Number
+	})
+	return d
+}
+
+// usbDescriptors lists the descriptors for /debug/usb, see
+// airsensor.Descriptors. Tests replace it.
+var usbDescriptors = airsensor.Descriptors
+
+// handleDebugUSB lists the descriptor trees of all connected sticks, with
+// their manufacturer and product strings. Reading those needs the sticks
+// opened, which may fail, e.g. for lack of permissions; they are left out
+// then.
+func handleDebugUSB(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	descs, err := usbDescriptors()
+	if err != nil {
+		slog.Warn("Enumerating USB devices failed", "err", err)
+		if len(descs) == 0 {
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, err.Error(), nil)
+			return
+		}
+	}
+	infos, err := airsensor.Discover()
+	if err != nil {
+		slog.Debug("Reading USB strings failed", "err", err)
+	}
+	type busAddr struct{ bus, addr int }
+	byAddr := make(map[busAddr]airsensor.SensorInfo)
+	for _, info := range infos {
+		byAddr[busAddr{info.Bus, info.Address}] = info
+	}
+	devs := []usbDevice{}
+	for _, desc := range descs {
+		d := newUSBDevice(desc)
+		info := byAddr[busAddr{desc.Bus, desc.Address}]
+		d.Manufacturer, d.ProductName = info.Manufacturer, info.ProductName
+		devs = append(devs, d)
+	}
+	writeJSON(w, http.StatusOK, devs)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// frameDumper writes every reading with its raw response frame as a
+// logfmt line, for figuring out the unknown parts of the frame:
+//
+//	ts=2017-06-01T12:00:00.123Z serial=1234 voc=612 valid=true raw="40 68 64 02 ..."
+type frameDumper struct {
+	mu sync.Mutex
+	w  io.Writer
+	// f is the file dumped to, nil for stdout.
+	f *os.File
+}
+
+// newFrameDumper appends to the file at path, or writes to stdout if path
+// is empty.
+func newFrameDumper(path string) (*frameDumper, error) {
+	if path == "" {
+		return &frameDumper{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &frameDumper{w: f, f: f}, nil
+}
+
+func (d *frameDumper) Write(_ context.Context, r *airsensor.Reading) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := fmt.Fprintf(d.w, "ts=%s serial=%s voc=%d valid=%t raw=\"% x\"\n",
+		formatTime(r.Timestamp.UTC(), time.RFC3339Nano), r.Serial, r.VOC, r.Valid, r.Raw)
+	return err
+}
+
+func (d *frameDumper) Close() error {
+	if d.f == nil {
+		return nil
+	}
+	return d.f.Close()
+}
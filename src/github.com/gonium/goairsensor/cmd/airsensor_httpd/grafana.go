@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// point is the average VOC value of the readings in a time bucket.
+type point struct {
+	Timestamp time.Time
+	VOC       float64
+}
+
+// bucketize averages the readings per bucket, starting each bucket at a
+// multiple of its length like the SQLite query does.
+func bucketize(rs []airsensor.Reading, bucket time.Duration) []point {
+	if bucket <= 0 {
+		bucket = 1
+	}
+	var (
+		points []point
+		sum    float64
+		n      int
+	)
+	for i, r := range rs {
+		sum += float64(r.VOC)
+		n++
+		start := r.Timestamp.Truncate(bucket)
+		if i+1 == len(rs) || !rs[i+1].Timestamp.Truncate(bucket).Equal(start) {
+			points = append(points, point{Timestamp: start, VOC: sum / float64(n)})
+			sum, n = 0, 0
+		}
+	}
+	return points
+}
+
+// grafanaTarget is the metric of the first stick; grafanaTarget/<serial>
+// selects a stick like /voc/<serial>.
+const grafanaTarget = "voc"
+
+// grafanaDatasource serves /search and /query of the Grafana JSON
+// datasource protocol (SimpleJSON and its successors). Points come from
+// SQLite if -sqlite is set and from the readings kept for /stats
+// otherwise.
+type grafanaDatasource struct {
+	m  *SensorManager
+	st *sqliteStore
+}
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs int64 `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target string `json:"target"`
+	// Datapoints are pairs of value and Unix time in milliseconds.
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// handleSearch lists the available targets.
+func (g *grafanaDatasource) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+	targets := []string{grafanaTarget}
+	for _, serial := range g.m.serials() {
+		targets = append(targets, grafanaTarget+"/"+serial)
+	}
+	writeJSON(w, http.StatusOK, targets)
+}
+
+// handleQuery returns the average VOC value per intervalMs of every
+// requested target over the requested range.
+func (g *grafanaDatasource) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+	var q grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("invalid query: %v", err), nil)
+		return
+	}
+	bucket := time.Duration(q.IntervalMs) * time.Millisecond
+
+	resp := []grafanaSeries{}
+	for _, t := range q.Targets {
+		serial, ok := strings.CutPrefix(t.Target, grafanaTarget+"/")
+		if !ok && t.Target != grafanaTarget {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter,
+				fmt.Sprintf("unknown target %q", t.Target), map[string]interface{}{"target": t.Target})
+			return
+		}
+		srv, ok := g.m.get(serial)
+		if !ok {
+			writeNoSensor(w, serial)
+			return
+		}
+
+		var points []point
+		if g.st != nil {
+			var err error
+			points, err = g.st.series(r.Context(), srv.serial, q.Range.From, q.Range.To, bucket)
+			if err != nil {
+				slog.Error("Querying SQLite failed", "err", err)
+				writeError(w, http.StatusInternalServerError, codeInternal, "query failed", nil)
+				return
+			}
+		} else {
+			points = bucketize(srv.readings(q.Range.From, q.Range.To), bucket)
+		}
+
+		s := grafanaSeries{Target: t.Target, Datapoints: [][2]float64{}}
+		for _, p := range points {
+			s.Datapoints = append(s.Datapoints, [2]float64{p.VOC, float64(p.Timestamp.UnixMilli())})
+		}
+		resp = append(resp, s)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
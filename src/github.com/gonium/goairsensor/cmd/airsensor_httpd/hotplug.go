@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	"time"
+)
+
+// hotplugOpenAttempts bounds the attempts to open a stick that was just
+// plugged in and may not be ready yet.
+const hotplugOpenAttempts = 5
+
+// hotplug adds sticks accepted by sel to m as they are plugged in, and
+// removes them as they are unplugged, until ctx is cancelled. Sticks are
+// listed every interval, see airsensor.Watch.
+func (m *SensorManager) hotplug(ctx context.Context, interval time.Duration, sel []airsensor.Selector) {
+	for ev := range airsensor.Watch(ctx, interval, sel...) {
+		id := ev.Info.ID()
+		switch {
+		case ev.Arrived && !m.has(id):
+			slog.Info("Sensor plugged in", "sensor", ev.Info)
+			go m.plugIn(ctx, id, sel)
+		case !ev.Arrived && m.remove(id):
+			slog.Info("Sensor unplugged, removed", "sensor", ev.Info)
+		}
+	}
+}
+
+// plugIn opens the stick with the given ID and adds it to m.
+func (m *SensorManager) plugIn(ctx context.Context, id string, sel []airsensor.Selector) {
+	sel = append([]airsensor.Selector{airsensor.ByID(id)}, sel...)
+	s, err := openOptions().OpenWithRetry(ctx, hotplugOpenAttempts, cfg.OpenDelay, sel...)
+	if err != nil {
+		slog.Error("Could not open plugged in sensor", "serial", id, "err", err)
+		return
+	}
+	if err := addSensor(m, s, sel); err != nil {
+		slog.Warn("Ignoring sensor", "sensor", s.Info(), "err", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var tagEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+// lineProtocol formats r as an InfluxDB line protocol point.
+func lineProtocol(r *airsensor.Reading) string {
+	return fmt.Sprintf("airsensor,device=%s,serial=%s voc=%di %d\n",
+		tagEscaper.Replace(r.Device.String()), tagEscaper.Replace(r.Serial),
+		r.VOC, r.Timestamp.UnixNano())
+}
+
+// influxStdout writes every valid reading to stdout as line protocol,
+// e.g. for telegraf to tail.
+func influxStdout(_ context.Context, r *airsensor.Reading) error {
+	if !r.Valid {
+		return nil
+	}
+	_, err := io.WriteString(os.Stdout, lineProtocol(r))
+	return err
+}
+
+// influxWriter batches valid readings and POSTs them to the /write
+// endpoint of an InfluxDB server every flush interval. With retry, a
+// failed batch is kept and sent again with the next one, within the
+// bounds of its queue.
+type influxWriter struct {
+	url    string
+	client *http.Client
+	retry  bool
+	batch  *sinkQueue
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// newInfluxWriter returns a writer to the database db at base. With
+// buffer > 0, failed batches are retried and up to buffer readings kept.
+// The batch is exported as metrics to mx.
+func newInfluxWriter(base, db string, every time.Duration, buffer int, mx *metrics) *influxWriter {
+	w := &influxWriter{
+		url: strings.TrimSuffix(base, "/") + "/write?precision=ns&db=" +
+			url.QueryEscape(db),
+		client: &http.Client{Timeout: 10 * time.Second},
+		retry:  buffer > 0,
+		batch:  newSinkQueue("influx", buffer, mx),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run(every)
+	return w
+}
+
+// Write adds r to the current batch. Failed batches are logged when they
+// are sent.
+func (w *influxWriter) Write(_ context.Context, r *airsensor.Reading) error {
+	if r.Valid {
+		w.batch.push(r)
+	}
+	return nil
+}
+
+func (w *influxWriter) run(every time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.flush()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// flush sends the current batch. A failed batch is dropped, or queued
+// again with retry.
+func (w *influxWriter) flush() {
+	rs := w.batch.take()
+	if len(rs) == 0 {
+		return
+	}
+	var body strings.Builder
+	for _, r := range rs {
+		body.WriteString(lineProtocol(r))
+	}
+
+	if err := w.post(context.Background(), body.String()); err != nil {
+		slog.Error("Failed to write to InfluxDB", "readings", len(rs), "retry", w.retry, "err", err)
+		if w.retry {
+			w.batch.requeue(rs)
+		}
+	}
+}
+
+// post sends body to the /write endpoint.
+func (w *influxWriter) post(ctx context.Context, body string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// halt stops flushing and waits for a flush in progress.
+func (w *influxWriter) halt() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// drain stops flushing and sends the pending readings until all are out
+// or ctx is done. Without retry, it gives up at the first failure.
+func (w *influxWriter) drain(ctx context.Context) (flushed, left int) {
+	w.halt()
+	failures := 0
+	for {
+		rs := w.batch.take()
+		if len(rs) == 0 {
+			return flushed, 0
+		}
+		var body strings.Builder
+		for _, r := range rs {
+			body.WriteString(lineProtocol(r))
+		}
+		err := w.post(ctx, body.String())
+		if err == nil {
+			flushed += len(rs)
+			failures = 0
+			continue
+		}
+		w.batch.requeue(rs)
+		slog.Warn("Failed to write to InfluxDB while draining", "readings", len(rs), "retry", w.retry, "err", err)
+		if !w.retry {
+			return flushed, w.batch.len()
+		}
+		failures++
+		select {
+		case <-time.After(retryDelay(failures)):
+		case <-ctx.Done():
+			return flushed, w.batch.len()
+		}
+	}
+}
+
+// Close stops flushing and drops the readings drain didn't send.
+func (w *influxWriter) Close() error {
+	w.halt()
+	dropQueued(w.batch)
+	return nil
+}
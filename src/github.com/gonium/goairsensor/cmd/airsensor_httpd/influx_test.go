@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/gonium/goairsensor/airsensor"
+	"testing"
+	"time"
+)
+
+func TestLineProtocol(t *testing.T) {
+	r := &airsensor.Reading{
+		VOC:       800,
+		Timestamp: time.Unix(0, 1700000000000000000),
+		Serial:    "a b",
+		Device:    airsensor.DeviceID{Vendor: airsensor.VendorID, Product: airsensor.ProductID2011},
+	}
+	want := "airsensor,device=03eb:2011,serial=a\\ b voc=800i 1700000000000000000\n"
+	if got := lineProtocol(r); got != want {
+		t.Errorf("lineProtocol() = %q, want %q", got, want)
+	}
+}
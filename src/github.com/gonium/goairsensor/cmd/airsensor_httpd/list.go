@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"os"
+	"text/tabwriter"
+)
+
+// listSensors prints a table of all connected sticks. It only enumerates
+// them and never claims an interface, so sticks in use by a running
+// daemon are listed, too. It returns the exit code: 0 if any stick was
+// found, 1 otherwise.
+func listSensors() int {
+	infos, err := airsensor.Discover()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Enumeration incomplete:", err)
+	}
+	if len(infos) == 0 {
+		fmt.Fprintf(os.Stderr, "No %s connected\n", airsensor.SupportedDevices)
+		return 1
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "BUS\tADDRESS\tPRODUCT\tSERIAL\tFIRMWARE\tMANUFACTURER\tNAME")
+	for _, info := range infos {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", info.Bus, info.Address, info.Product, orDash(info.Serial), info.Firmware, orDash(info.Manufacturer), orDash(info.ProductName))
+	}
+	w.Flush()
+	return 0
+}
+
+// orDash returns s, or "-" for a string the stick doesn't have.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
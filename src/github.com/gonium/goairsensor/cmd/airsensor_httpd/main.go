@@ -12,187 +12,455 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// rawread attempts to read from the specified USB device.
+// airsensor_httpd serves VOC readings of an iAQ-Stick over HTTP.
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"flag"
-	"github.com/davecgh/go-spew/spew"
-	"github.com/google/gousb"
-	"log"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"golang.org/x/crypto/acme/autocert"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
-	device   = flag.String("device", "03eb:2013", "Device to which to connect")
-	config   = flag.Int("config", 1, "Endpoint to which to connect")
-	iface    = flag.Int("interface", 0, "Endpoint to which to connect")
-	setup    = flag.Int("setup", 0, "Endpoint to which to connect")
-	endpoint = flag.Int("endpoint", 1, "Endpoint to which to connect")
-	debug    = flag.Int("debug", 3, "Debug level for libusb")
+	once         = flag.Bool("once", false, "Print a single reading as JSON to stdout and exit")
+	selftestMode = flag.Bool("selftest", false, "Check that a stick can be found, opened and read, and exit")
+	list         = flag.Bool("list", false, "List the connected sticks and exit")
+	samples      = flag.Int("samples", 0, "Take this many readings back to back, print them with their mean and standard deviation, and exit")
+	showVersion  = flag.Bool("version", false, "Print the version and build information and exit")
+	protocol     = flag.Bool("protocol", false, "Print the layout of the request and response frames and exit")
+	ndjson       = flag.Bool("ndjson", false, "Print a reading as a line of JSON to stdout every -interval until interrupted")
 )
 
-func read_le_int16(data []byte) (ret int16) {
-	buf := bytes.NewBuffer(data)
-	binary.Read(buf, binary.LittleEndian, &ret)
-	return
+// cfg holds the effective settings.
+var cfg Config
+
+const vocUnit = "ppm CO2-equivalent"
+
+// openOptions returns the options for opening a stick.
+func openOptions() airsensor.Options {
+	return airsensor.Options{
+		AutoDetach:  cfg.Detach,
+		Config:      cfg.USBConfig,
+		Interface:   cfg.Interface,
+		AltSetting:  cfg.Setup,
+		InEndpoint:  cfg.Endpoint,
+		OutEndpoint: cfg.OutEndpoint,
+		Transport:   cfg.Transport,
+	}
 }
 
-func main() {
-	flag.Parse()
-
-	// Only one context should be needed for an application.  It should always be closed.
-	ctx := gousb.NewContext()
-	defer ctx.Close()
-
-	ctx.Debug(*debug)
-
-	log.Printf("Scanning for device %q...", *device)
-
-	//	// ListDevices is used to find the devices to open.
-	//	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-	//		if fmt.Sprintf("%s:%s", desc.Vendor, desc.Product) != *device {
-	//			return false
-	//		}
-	//
-	//		// The usbid package can be used to print out human readable information.
-	//		fmt.Printf("  Protocol: %d\n", desc)
-	//
-	//		// The configurations can be examined from the Descriptor, though they can only
-	//		// be set once the device is opened.  All configuration references must be closed,
-	//		// to free up the memory in libusb.
-	//		for _, cfg := range desc.Configs {
-	//			// This loop just uses more of the built-in and usbid pretty printing to list
-	//			// the USB devices.
-	//			fmt.Printf("  %s:\n", cfg)
-	//			for _, alt := range cfg.Interfaces {
-	//				fmt.Printf("    --------------\n")
-	//				for _, iface := range alt.AltSettings {
-	//					fmt.Printf("    %s\n", iface)
-	//					for _, end := range iface.Endpoints {
-	//						fmt.Printf("      %v\n", end)
-	//					}
-	//				}
-	//			}
-	//			fmt.Printf("    --------------\n")
-	//		}
-	//
-	//		return true
-	//	})
-	//
-	//	// All Devices returned from ListDevices must be closed.
-	//	defer func() {
-	//		for _, d := range devs {
-	//			d.Close()
-	//		}
-	//	}()
-	//
-	//	// ListDevices can occaionally fail, so be sure to check its return value.
-	//	if err != nil {
-	//		log.Fatalf("list: %s", err)
-	//	}
-	//
-	//	if len(devs) == 0 {
-	//		log.Fatalf("no devices found")
-	//	}
-	//
-	//	dev := devs[0]
-	//	dev.Reset()
-	//	//dev.ReadTimeout = (1 * time.Second)
-	//	//dev.WriteTimeout = (1 * time.Second)
-	//	//dev.ControlTimeout = (1 * time.Second)
-	//
-	//	//log.Printf("Using device descriptor: ")
-	//	//spew.Dump(dev.Descriptor)
-	//
-	//	cfg, err := dev.ActiveConfig()
-	//	if err != nil {
-	//		log.Fatalf("Cannot get active config: %s", err)
-	//	}
-	//	log.Printf("Selecting config %d, endpoint 0x%x", cfg,
-	//		uint8(1)|uint8(gousb.ENDPOINT_DIR_IN))
-	//	ep_read, err := dev.OpenEndpoint(cfg, uint8(0),
-	//		uint8(0), uint8(1)|uint8(gousb.ENDPOINT_DIR_IN))
-	//	if err != nil {
-	//		log.Fatalf("Failed to open read endpoint: %s", err)
-	//	}
-	//	log.Printf("Got read endpoint: ")
-	//	spew.Dump(ep_read)
-	//
-	//	ep_write, err := dev.OpenEndpoint(uint8(*config), uint8(0),
-	//		uint8(0), uint8(2)|uint8(gousb.ENDPOINT_DIR_OUT))
-	//	if err != nil {
-	//		log.Fatalf("Failed to open write endpoint: %s", err)
-	//	}
-	//	log.Printf("Got write endpoint: ")
-	//	spew.Dump(ep_write)
-
-	// Open any device with a given VID/PID using a convenience function.
-	dev, err := ctx.OpenDeviceWithVIDPID(0x03eb, 0x2013)
-	if err != nil {
-		log.Fatalf("Could not open a device: %v", err)
+// configure applies the flags to a freshly opened sensor. Resets are
+// counted in mx, unless it is nil.
+func configure(s *airsensor.Sensor, mx *metrics) {
+	s.SetDebug(int(debugLevel.Load()))
+	s.ReadTimeout = cfg.ReadTimeout
+	s.WriteTimeout = cfg.WriteTimeout
+	s.Warmup = cfg.Warmup
+	s.Calibration = airsensor.Calibration{Scale: cfg.CalScale, Offset: cfg.CalOffset}
+	s.PreRequestReads = cfg.PreRequestReads
+	s.Flush = cfg.Flush
+	s.FlushTimeout = cfg.FlushTimeout
+	s.FrameSize = cfg.FrameSize
+	s.TemperatureOffset = cfg.TemperatureOffset
+	s.Parse = vocParse
+	s.ResetAfter = cfg.ResetAfter
+	s.MinResetInterval = cfg.MinResetInterval
+	if mx != nil {
+		serial, device := s.Info().ID(), s.Info().Device().String()
+		s.OnReset = func(err error) { mx.observeReset(device, serial, err) }
 	}
-	defer dev.Close()
+}
 
-	// Claim the default interface using a convenience function.
-	// The default interface is always #0 alt #0 in the currently active
-	// config.
-	intf, done, err := dev.DefaultInterface()
-	if err != nil {
-		log.Fatalf("%s.DefaultInterface(): %v", dev, err)
+// withBreaker wraps s in a circuit breaker, unless -breaker-threshold is 0.
+// Its state is exported to mx.
+func withBreaker(mx *metrics, device, serial string, s airsensor.SensorReader) airsensor.SensorReader {
+	if cfg.BreakerThreshold <= 0 {
+		return s
 	}
-	defer done()
+	b := airsensor.NewBreaker(s, cfg.BreakerThreshold, cfg.BreakerCooldown)
+	b.OnStateChange = func(st airsensor.BreakerState) { mx.observeBreaker(device, serial, st) }
+	return b
+}
+
+// simulatedSerial is the serial of the sensor served with -simulate.
+const simulatedSerial = "simulated"
 
-	// Open an IN endpoint.
-	ep_read, err := intf.InEndpoint(1)
+// openSensors opens the sticks accepted by sel and adds them to m.
+func openSensors(m *SensorManager, sel []airsensor.Selector) {
+	// The sticks may not be enumerated yet when started at boot.
+	sensors, err := openOptions().OpenAllWithRetry(context.Background(), cfg.OpenAttempts, cfg.OpenDelay, sel...)
 	if err != nil {
-		log.Fatalf("%s.OutEndpoint(0): %v", intf, err)
+		fatal("Could not open sensor", "err", err)
 	}
+	for _, s := range sensors {
+		if err := addSensor(m, s, sel); err != nil {
+			slog.Warn("Ignoring sensor", "sensor", s.Info(), "err", err)
+			continue
+		}
+	}
+}
 
-	// Open an OUT endpoint.
-	ep_write, err := intf.OutEndpoint(2)
-	if err != nil {
-		log.Fatalf("%s.OutEndpoint(0): %v", intf, err)
+// addSensor configures the freshly opened s and adds it to m, reopening
+// it when it is unplugged and plugged in again. s is closed if it can't
+// be added.
+func addSensor(m *SensorManager, s *airsensor.Sensor, sel []airsensor.Selector) error {
+	configure(s, m.mx)
+	serial, device := s.Info().ID(), s.Info().Device().String()
+	reopen := append([]airsensor.Selector{airsensor.ByID(serial)}, sel...)
+	rs := airsensor.NewReconnecting(s, func() (airsensor.SensorReader, error) {
+		s, err := openOptions().Open(reopen...)
+		if err != nil {
+			return nil, err
+		}
+		configure(s, m.mx)
+		return s, nil
+	}, cfg.OpenDelay)
+	if err := m.add(newServer(withBreaker(m.mx, device, serial, rs), m.mx, serial, device, cfg.EMAAlpha, cfg.StatsSize, cfg.MinReadInterval, cfg.HoldLastValid, airsensor.Anomaly{Sigma: cfg.AnomalySigma, Window: cfg.AnomalyWindow}, airsensor.Baseline{HalfLife: cfg.BaselineHalfLife})); err != nil {
+		rs.Close()
+		return err
 	}
+	slog.Info("Opened sensor", "serial", serial)
+	return nil
+}
 
-	var buf []byte
-	// Read invalid bytes from device
-	num, err := ep_read.Read(buf)
-	if err != nil {
-		log.Fatal("Failed to read pending bytes into buffer")
+func main() {
+	cfg.registerFlags(flag.CommandLine)
+	if err := cfg.parse(flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	log.Printf("Read %d bytes into temporary buffer", num)
 
-	// request data step 1: send request command
-	buf = []byte("\x40\x68\x2a\x54\x52\x0a\x40\x40\x40\x40\x40\x40\x40\x40\x40\x40")
-	num, err = ep_write.Write(buf)
-	if num != len(buf) {
-		log.Fatalf("Failed to write request command: %s", err)
+	if *showVersion {
+		printVersion()
+		os.Exit(0)
+	}
+	var err error
+	if vocParse, err = parseConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *protocol {
+		printProtocol()
+		os.Exit(0)
 	}
-	spew.Printf("Request data - wrote %d bytes: % x\n", num, buf)
 
-	// request data step 2: read response
-	num, err = ep_read.Read(buf)
+	if tsFormat, err = parseTimestampFormat(cfg.TimestampFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := setupLogging(cfg.LogFormat, cfg.LogLevel); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *once || *selftestMode || *list || *samples > 0 {
+		// Keep stderr quiet, only the result is printed.
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	if cfg.EMAAlpha < 0 || cfg.EMAAlpha > 1 {
+		fatal("Invalid -ema-alpha, must lie in [0, 1]", "ema_alpha", cfg.EMAAlpha)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		fatal("-tls-cert and -tls-key must be given together")
+	}
+	if cfg.StatsSize < 0 {
+		fatal("Invalid -stats-size, must not be negative", "stats_size", cfg.StatsSize)
+	}
+	buckets, err := parseBuckets(cfg.HistogramBuckets)
 	if err != nil {
-		log.Fatal("Failed to read pending bytes into buffer")
-	}
-	spew.Printf("Response data - read %d bytes: % x\n", num, buf)
-	spew.Dump(buf[2:4])
-	voc := read_le_int16(buf[2:4])
-	// check voc range - sensor docs says between 450 and 2000.
-	// everything else is garbage.
-	if (voc >= 450) && (voc <= 2000) {
-		log.Printf("VOC concentration: %d ppm CO2-equivalent", voc)
+		fatal("Invalid -histogram-buckets", "err", err)
+	}
+	reg := newRegistry()
+	mx := newMetrics(reg, buckets)
+	if _, ok := socketPath(cfg.Listen); ok && cfg.MDNS {
+		fatal("-mdns needs a TCP -listen address")
+	}
+	if cfg.IntervalJitter < 0 || cfg.IntervalJitter >= 1 {
+		fatal("Invalid -interval-jitter, must lie in [0, 1)", "interval_jitter", cfg.IntervalJitter)
+	}
+	if cfg.PreRequestReads < airsensor.DrainAuto {
+		fatal("Invalid -pre-request-reads, must be -1 or more", "pre_request_reads", cfg.PreRequestReads)
+	}
+	if cfg.FlushTimeout < 0 {
+		fatal("Invalid -flush-timeout, must not be negative", "flush_timeout", cfg.FlushTimeout)
+	}
+	if cfg.FrameSize <= 0 {
+		fatal("Invalid -frame-size, must be positive", "frame_size", cfg.FrameSize)
+	}
+	if cfg.TemperatureOffset < 0 || cfg.TemperatureOffset >= cfg.FrameSize {
+		fatal("Invalid -temperature-offset, must lie within -frame-size", "temperature_offset", cfg.TemperatureOffset)
+	}
+	if cfg.RawRetention < 0 {
+		fatal("Invalid -raw-retention, must not be negative", "raw_retention", cfg.RawRetention)
+	}
+	if cfg.AnomalySigma < 0 {
+		fatal("Invalid -anomaly-sigma, must not be negative", "anomaly_sigma", cfg.AnomalySigma)
+	}
+	if cfg.AnomalySigma > 0 && cfg.AnomalyWindow < 2 {
+		fatal("Invalid -anomaly-window, must be 2 or more", "anomaly_window", cfg.AnomalyWindow)
+	}
+	if cfg.BaselineHalfLife < 0 {
+		fatal("Invalid -baseline-half-life, must not be negative", "baseline_half_life", cfg.BaselineHalfLife)
+	}
+	if cfg.AnomalyWebhook && (cfg.WebhookURL == "" || cfg.AnomalySigma == 0) {
+		fatal("-anomaly-webhook needs -webhook-url and -anomaly-sigma")
+	}
+	if cfg.DrainTimeout < 0 {
+		fatal("Invalid -drain-timeout, must not be negative", "drain_timeout", cfg.DrainTimeout)
+	}
+	if cfg.SinkBuffer < 0 {
+		fatal("Invalid -sink-buffer, must not be negative", "sink_buffer", cfg.SinkBuffer)
+	}
+	if cfg.ChangeDelta < 0 {
+		fatal("Invalid -change-delta, must not be negative", "change_delta", cfg.ChangeDelta)
+	}
+	if airsensor.SupportedDevices, err = airsensor.ParseDeviceIDs(cfg.Device); err != nil {
+		fatal("Invalid -device", "err", err)
+	}
+
+	debugLevel.Store(int32(cfg.Debug))
+	if *list {
+		os.Exit(listSensors())
+	}
+
+	slog.Info("Scanning for device", "device", cfg.Device)
+
+	var sel []airsensor.Selector
+	if cfg.Bus != 0 || cfg.Address != 0 {
+		if cfg.Bus == 0 || cfg.Address == 0 {
+			fatal("-bus and -address must be given together")
+		}
+		sel = append(sel, airsensor.ByBusAddress(cfg.Bus, cfg.Address))
+	}
+	if cfg.Serial != "" {
+		sel = append(sel, airsensor.BySerial(cfg.Serial))
+	}
+
+	if *once {
+		os.Exit(readOnce(sel))
+	}
+	if *selftestMode {
+		os.Exit(runSelftest(sel))
+	}
+	if *samples > 0 {
+		os.Exit(readSamples(*samples, sel))
+	}
+	if *ndjson {
+		if cfg.Interval <= 0 {
+			fatal("-ndjson needs -interval")
+		}
+		os.Exit(streamNDJSON(mx, cfg.Interval, cfg.IntervalJitter, sel))
+	}
+
+	m := newSensorManager(reg, mx)
+	defer m.close()
+	if cfg.Simulate {
+		s := airsensor.NewSimulatedSensor(simulatedSerial, time.Now().UnixNano())
+		s.Calibration = airsensor.Calibration{Scale: cfg.CalScale, Offset: cfg.CalOffset}
+		if err := m.add(newServer(s, mx, simulatedSerial, airsensor.SimulatedDevice.String(), cfg.EMAAlpha, cfg.StatsSize, cfg.MinReadInterval, cfg.HoldLastValid, airsensor.Anomaly{Sigma: cfg.AnomalySigma, Window: cfg.AnomalyWindow}, airsensor.Baseline{HalfLife: cfg.BaselineHalfLife})); err != nil {
+			fatal("Could not add simulated sensor", "err", err)
+		}
+		slog.Info("Simulating sensor", "serial", simulatedSerial)
 	} else {
-		log.Printf("ERROR: invalid VOC value %d received", voc)
+		openSensors(m, sel)
+	}
+	for name, vs := range cfg.Virtual {
+		if err := m.addVirtual(name, vs); err != nil {
+			fatal("Invalid -virtual", "err", err)
+		}
+	}
+	if cfg.StateFile != "" {
+		if err := m.loadState(cfg.StateFile); err != nil {
+			slog.Warn("Could not restore state", "file", cfg.StateFile, "err", err)
+		}
+	}
+
+	// The API has a mux of its own; DefaultServeMux carries the pprof
+	// handlers, which are served separately.
+	mux := http.NewServeMux()
+
+	var sinks []namedSink
+	defer func() {
+		// Nothing is written once the poll loops ended, so the sinks
+		// can hand on what they hold before they are closed.
+		m.stopPolling()
+		if cfg.DrainTimeout > 0 {
+			drainAll(sinks, cfg.DrainTimeout)
+		}
+		closeAll(sinks)
+	}()
+	// filter applies -changes-only to the sinks storing or publishing
+	// readings. Dumps, alerts and the live stream see every reading.
+	filter := func(s Sink) Sink {
+		if cfg.ChangesOnly {
+			return newChangesOnly(s, cfg.ChangeDelta, cfg.MaxGap)
+		}
+		return s
+	}
+	// buffer queues the readings of outputs that may fail for a while,
+	// see bufferedSink.
+	buffer := func(name string, s Sink) Sink {
+		if cfg.SinkBuffer > 0 {
+			return newBufferedSink(name, s, cfg.SinkBuffer, mx)
+		}
+		return s
+	}
+	if cfg.MQTTBroker != "" {
+		p, err := newMQTTPublisher(cfg.MQTTBroker, cfg.MQTTTopic, cfg.MQTTQoS, cfg.MQTTUsername, cfg.MQTTPassword)
+		if err != nil {
+			fatal("Could not set up MQTT", "err", err)
+		}
+		sinks = append(sinks, namedSink{"mqtt", filter(buffer("mqtt", p))})
 	}
 
-	// request data step 3: flush
-	num, err = ep_read.Read(buf)
+	if cfg.InfluxURL != "" {
+		w := newInfluxWriter(cfg.InfluxURL, cfg.InfluxDB, cfg.InfluxFlush, cfg.SinkBuffer, mx)
+		sinks = append(sinks, namedSink{"influx", filter(w)})
+	}
+	if cfg.InfluxStdout {
+		sinks = append(sinks, namedSink{"influx-stdout", filter(sinkFunc(influxStdout))})
+	}
+	if cfg.PushURL != "" {
+		sinks = append(sinks, namedSink{"push", newPusher(cfg.PushURL, reg, mx)})
+	}
+	if cfg.CSV != "" {
+		sinks = append(sinks, namedSink{"csv", filter(buffer("csv", newCSVLogger(cfg.CSV)))})
+	}
+
+	grafana := &grafanaDatasource{m: m}
+	if cfg.SQLite != "" {
+		st, err := openSQLiteStore(cfg.SQLite, cfg.RawRetention)
+		if err != nil {
+			fatal("Could not open SQLite database", "err", err)
+		}
+		sinks = append(sinks, namedSink{"sqlite", filter(buffer("sqlite", st))})
+		mux.HandleFunc("/history", st.handleHistory)
+		grafana.st = st
+	}
+
+	if cfg.Dump {
+		d, err := newFrameDumper(cfg.DumpFile)
+		if err != nil {
+			fatal("Could not open dump file", "err", err)
+		}
+		sinks = append(sinks, namedSink{"dump", d})
+	}
+
+	if cfg.WebhookURL != "" {
+		a := newAlerter(cfg.WebhookURL, float64(cfg.WarnThreshold), float64(cfg.CritThreshold), cfg.AlertHysteresis, cfg.AnomalyWebhook)
+		sinks = append(sinks, namedSink{"webhook", a})
+	}
+
+	h := newHub()
+	if cfg.Interval > 0 {
+		// stdout carries line protocol or dumped frames when requested,
+		// so only print human-readable lines otherwise.
+		if !cfg.InfluxStdout && !(cfg.Dump && cfg.DumpFile == "") {
+			sinks = append(sinks, namedSink{"stdout", filter(sinkFunc(printReading))})
+		}
+		sinks = append(sinks, namedSink{"stream", h})
+		m.poll(cfg.Interval, cfg.IntervalJitter, sinks)
+	} else if len(sinks) > 0 {
+		slog.Warn("Outputs are only written when polling, set -interval")
+	}
+
+	stale := cfg.StaleAfter
+	if stale == 0 {
+		stale = 3 * cfg.Interval
+	}
+	m.exportVirtual(stale)
+	mux.HandleFunc("/", dashboardHandler(dashboardParams{
+		Warn:    cfg.WarnThreshold,
+		Crit:    cfg.CritThreshold,
+		Minutes: cfg.DashboardMinutes,
+	}))
+	mux.HandleFunc("/voc", m.handleVOC)
+	mux.HandleFunc("/voc/", m.handleVOC)
+	mux.HandleFunc("/read", m.handleRead)
+	mux.HandleFunc("/healthz", m.healthzHandler())
+	mux.HandleFunc("/readyz", m.readyzHandler(stale))
+	mux.HandleFunc("/stats", m.handleStats)
+	mux.HandleFunc("/search", grafana.handleSearch)
+	mux.HandleFunc("/query", grafana.handleQuery)
+	mux.Handle("/metrics", metricsHandler(m.reg))
+	mux.HandleFunc("/stream", h.handleStream)
+	mux.HandleFunc("/debug/usb", handleDebugUSB)
+	mux.HandleFunc("/debug/level", m.handleDebugLevel)
+	mux.HandleFunc("/openapi.json", openAPIHandler())
+	mux.HandleFunc("/version", handleVersion)
+	mux.HandleFunc("/config", handleConfig)
+
+	if cfg.Pprof {
+		go servePprof(cfg.PprofListen)
+	}
+	if cfg.MDNS {
+		z, err := advertise(cfg.Listen, m.serials())
+		if err != nil {
+			fatal("Could not advertise over mDNS", "err", err)
+		}
+		defer z.Shutdown()
+	}
+
+	// Shut down cleanly on SIGINT and SIGTERM, so the deferred cleanups
+	// run.
+	var handler http.Handler = mux
+	if cfg.Gzip {
+		handler = withGzip(handler)
+	}
+	if cfg.AuthToken != "" {
+		handler = withBearerAuth(handler, cfg.AuthToken)
+	}
+	ln, err := listen(cfg.Listen, cfg.SocketMode)
 	if err != nil {
-		log.Fatal("Failed to read pending bytes into buffer")
+		fatal("Could not listen", "addr", cfg.Listen, "err", err)
+	}
+	hs := &http.Server{Handler: handler}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Hotplug > 0 && !cfg.Simulate {
+		go m.hotplug(ctx, cfg.Hotplug, sel)
 	}
-	log.Printf("Read %d bytes into temporary buffer", num)
+	go func() {
+		<-ctx.Done()
+		slog.Info("Shutting down")
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		hs.Shutdown(sctx)
+	}()
 
+	switch {
+	case cfg.ACMEDomain != "":
+		// Certificates are obtained with the TLS-ALPN-01 challenge,
+		// so the server has to be reachable on port 443.
+		am := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomain),
+			Cache:      autocert.DirCache(cfg.ACMECache),
+		}
+		hs.TLSConfig = am.TLSConfig()
+		slog.Info("Listening with TLS", "addr", cfg.Listen, "domain", cfg.ACMEDomain)
+		err = hs.ServeTLS(ln, "", "")
+	case cfg.TLSCert != "" || cfg.TLSKey != "":
+		slog.Info("Listening with TLS", "addr", cfg.Listen)
+		err = hs.ServeTLS(ln, cfg.TLSCert, cfg.TLSKey)
+	default:
+		slog.Info("Listening", "addr", cfg.Listen)
+		err = hs.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		slog.Error("HTTP server failed", "err", err)
+	}
+	if cfg.StateFile != "" {
+		if err := m.saveState(cfg.StateFile); err != nil {
+			slog.Error("Could not save state", "file", cfg.StateFile, "err", err)
+		}
+	}
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SensorManager owns one server per opened stick and runs their poll
+// loops. Sticks are addressed by serial, see airsensor.SensorInfo.ID.
+// With hotplug, sticks come and go while requests are served.
+type SensorManager struct {
+	mu       sync.RWMutex
+	servers  []*server
+	bySerial map[string]*server
+	// virtual holds the virtual sensors by name.
+	virtual map[string]*virtualServer
+	// polling is set by poll, so sticks added later are polled, too.
+	polling *pollParams
+	// reg holds the metrics served on /metrics, mx the ones of the
+	// daemon registered with it.
+	reg *prometheus.Registry
+	mx  *metrics
+}
+
+// pollParams are the arguments of poll.
+type pollParams struct {
+	interval time.Duration
+	jitter   float64
+	sinks    []namedSink
+}
+
+// newSensorManager returns a manager exporting its metrics from reg,
+// which mx has to be registered with, see newMetrics.
+func newSensorManager(reg *prometheus.Registry, mx *metrics) *SensorManager {
+	return &SensorManager{bySerial: make(map[string]*server), virtual: make(map[string]*virtualServer), reg: reg, mx: mx}
+}
+
+// add registers srv and starts polling it if the other sticks are polled.
+// It fails if another stick has the same serial.
+func (m *SensorManager) add(srv *server) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.bySerial[srv.serial]; ok {
+		return fmt.Errorf("duplicate sensor serial %q", srv.serial)
+	}
+	m.servers = append(m.servers, srv)
+	m.bySerial[srv.serial] = srv
+	if p := m.polling; p != nil {
+		go srv.poll(p.interval, p.jitter, p.sinks)
+	}
+	return nil
+}
+
+// remove stops polling the stick with the given serial, closes it and
+// drops its metrics. It reports whether there was such a stick.
+func (m *SensorManager) remove(serial string) bool {
+	m.mu.Lock()
+	srv, ok := m.bySerial[serial]
+	if ok {
+		delete(m.bySerial, serial)
+		m.servers = slices.DeleteFunc(m.servers, func(s *server) bool { return s == srv })
+	}
+	m.mu.Unlock()
+	if !ok {
+		return false
+	}
+	srv.stop()
+	if err := srv.sensor.Close(); err != nil {
+		slog.Warn("Closing removed sensor failed", "serial", serial, "err", err)
+	}
+	m.mx.forget(serial)
+	return true
+}
+
+// has reports whether a stick with the given serial is registered.
+func (m *SensorManager) has(serial string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.bySerial[serial]
+	return ok
+}
+
+// get returns the server of the stick with the given serial, or the first
+// stick opened if serial is empty.
+func (m *SensorManager) get(serial string) (*server, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if serial == "" {
+		if len(m.servers) == 0 {
+			return nil, false
+		}
+		return m.servers[0], true
+	}
+	srv, ok := m.bySerial[serial]
+	return srv, ok
+}
+
+// all returns the servers of all sticks in the order they were opened.
+func (m *SensorManager) all() []*server {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.servers)
+}
+
+// serials returns the serials of all sticks in the order they were opened.
+func (m *SensorManager) serials() []string {
+	var serials []string
+	for _, srv := range m.all() {
+		serials = append(serials, srv.serial)
+	}
+	return serials
+}
+
+// poll starts a poll loop per stick, including sticks added later.
+func (m *SensorManager) poll(interval time.Duration, jitter float64, sinks []namedSink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.polling = &pollParams{interval: interval, jitter: jitter, sinks: sinks}
+	for _, srv := range m.servers {
+		go srv.poll(interval, jitter, sinks)
+	}
+}
+
+// setDebug changes the libusb debug level of all sticks.
+func (m *SensorManager) setDebug(level int) {
+	for _, srv := range m.all() {
+		if d, ok := srv.sensor.(interface{ SetDebug(int) }); ok {
+			d.SetDebug(level)
+		}
+	}
+}
+
+// stopPolling ends the poll loops of all sticks.
+func (m *SensorManager) stopPolling() {
+	for _, srv := range m.all() {
+		srv.stop()
+	}
+}
+
+// close closes all sensors.
+func (m *SensorManager) close() {
+	for _, srv := range m.all() {
+		srv.stop()
+		srv.sensor.Close()
+	}
+}
+
+// requestedSerial returns the stick selected by a request, given either
+// as /voc/<serial> or as /voc?device=<serial>.
+func requestedSerial(r *http.Request, prefix string) string {
+	if serial := strings.TrimPrefix(r.URL.Path, prefix); serial != r.URL.Path {
+		return serial
+	}
+	return r.URL.Query().Get("device")
+}
+
+// handleVOC reads the selected stick or virtual sensor, the first stick
+// by default.
+func (m *SensorManager) handleVOC(w http.ResponseWriter, r *http.Request) {
+	serial := requestedSerial(r, "/voc/")
+	srv, ok := m.get(serial)
+	if !ok {
+		if v, ok := m.virtual[serial]; ok {
+			v.handleVOC(w, r)
+			return
+		}
+		writeNoSensor(w, serial)
+		return
+	}
+	srv.handleVOC(w, r)
+}
+
+// handleRead takes a fresh reading of the selected stick, the first one by
+// default.
+func (m *SensorManager) handleRead(w http.ResponseWriter, r *http.Request) {
+	serial := r.URL.Query().Get("device")
+	srv, ok := m.get(serial)
+	if !ok {
+		writeNoSensor(w, serial)
+		return
+	}
+	srv.handleRead(w, r)
+}
+
+// handleStats summarizes the readings of the selected stick over
+// ?window=<duration>, by default the last 5 minutes.
+func (m *SensorManager) handleStats(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	window := 5 * time.Minute
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeInvalidParameter(w, "window", fmt.Sprintf("invalid window %q", v))
+			return
+		}
+		window = d
+	}
+	serial := r.URL.Query().Get("device")
+	srv, ok := m.get(serial)
+	if !ok {
+		writeNoSensor(w, serial)
+		return
+	}
+	writeJSON(w, http.StatusOK, srv.stats(window))
+}
+
+type managerHealthResponse struct {
+	Status  string           `json:"status"`
+	Sensors []healthResponse `json:"sensors"`
+}
+
+// healthzHandler reports healthy if every stick is alive, see server.live.
+// ?device=<serial> restricts the check to one stick.
+func (m *SensorManager) healthzHandler() http.HandlerFunc {
+	return m.checkHandler((*server).live)
+}
+
+// readyzHandler reports healthy if every stick is ready, see
+// server.ready. ?device=<serial> restricts the check to one stick.
+func (m *SensorManager) readyzHandler(staleAfter time.Duration) http.HandlerFunc {
+	return m.checkHandler(func(srv *server) healthResponse {
+		return srv.ready(staleAfter)
+	})
+}
+
+// checkHandler answers 200 if check passes for every stick and 503
+// otherwise.
+func (m *SensorManager) checkHandler(check func(*server) healthResponse) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowGet(w, r) {
+			return
+		}
+		servers := m.all()
+		if serial := r.URL.Query().Get("device"); serial != "" {
+			srv, ok := m.get(serial)
+			if !ok {
+				writeNoSensor(w, serial)
+				return
+			}
+			servers = []*server{srv}
+		}
+
+		resp := managerHealthResponse{Status: "ok"}
+		status := http.StatusOK
+		for _, srv := range servers {
+			h := check(srv)
+			if h.Status != "ok" {
+				resp.Status, status = "unhealthy", http.StatusServiceUnavailable
+			}
+			resp.Sensors = append(resp.Sensors, h)
+		}
+		writeJSON(w, status, resp)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"github.com/grandcat/zeroconf"
+	"net"
+	"os"
+	"strings"
+)
+
+const mdnsService = "_airsensor._tcp"
+
+// advertise announces the HTTP service listening on listen over mDNS, with
+// the serials of the opened sticks in a TXT record. The returned server
+// must be shut down to withdraw the announcement.
+func advertise(listen string, serials []string) (*zeroconf.Server, error) {
+	_, p, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+	port, err := net.LookupPort("tcp", p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("could not get hostname for mDNS instance: %w", err)
+	}
+	txt := []string{"serial=" + strings.Join(serials, ",")}
+	return zeroconf.Register(host, mdnsService, "local.", port, txt, nil)
+}
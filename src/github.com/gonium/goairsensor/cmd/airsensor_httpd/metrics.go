@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// metrics holds the collectors of the daemon registered with one
+// registry, see newMetrics.
+type metrics struct {
+	voc              *prometheus.GaugeVec
+	vocCalibrated    *prometheus.GaugeVec
+	vocSmoothed      *prometheus.GaugeVec
+	vocAboveBaseline *prometheus.GaugeVec
+	vocHistogram     *prometheus.HistogramVec
+	temperature      *prometheus.GaugeVec
+	readErrors       *prometheus.CounterVec
+	anomalies        *prometheus.CounterVec
+	validRatio       *prometheus.GaugeVec
+	resets           *prometheus.CounterVec
+	breakerOpen      *prometheus.GaugeVec
+	pushFailures     *prometheus.CounterVec
+	sinkBuffered     *prometheus.GaugeVec
+	sinkDropped      *prometheus.CounterVec
+	buildInfo        *prometheus.GaugeVec
+}
+
+// defaultHistogramBuckets are the upper bounds of the histogram buckets,
+// spanning the sensor range.
+const defaultHistogramBuckets = "500,600,700,800,900,1000,1200,1400,1700,2000"
+
+// parseBuckets parses comma-separated, strictly increasing bucket bounds.
+func parseBuckets(s string) ([]float64, error) {
+	var buckets []float64
+	for _, f := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q: %w", f, err)
+		}
+		if n := len(buckets); n > 0 && b <= buckets[n-1] {
+			return nil, fmt.Errorf("bucket bounds %s not strictly increasing", s)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// newRegistry returns a registry with the Go runtime and process
+// metrics, which the metrics of the daemon are registered with instead of
+// the global default one.
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return reg
+}
+
+// register registers c with reg. If an equal collector is registered
+// already, that one is returned to be used instead, rather than panicking
+// like MustRegister; other failures are logged.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	err := reg.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	switch {
+	case err == nil:
+	case errors.As(err, &are):
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing
+		}
+	default:
+		slog.Error("Could not register metrics", "err", err)
+	}
+	return c
+}
+
+// newMetrics creates the metrics and registers them with reg, the VOC
+// histogram with the given buckets. If reg holds them already, those are
+// used.
+func newMetrics(reg prometheus.Registerer, buckets []float64) *metrics {
+	stick := []string{"device", "serial", "location"}
+	mx := &metrics{
+		voc: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_voc_ppm",
+			Unit: "ppm",
+			Help: "Last valid VOC concentration in ppm CO2-equivalent.",
+		}, stick)),
+		vocCalibrated: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_voc_calibrated_ppm",
+			Unit: "ppm",
+			Help: "Last valid VOC concentration after calibration in ppm CO2-equivalent.",
+		}, stick)),
+		vocSmoothed: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_voc_smoothed_ppm",
+			Unit: "ppm",
+			Help: "Smoothed calibrated VOC concentration in ppm CO2-equivalent.",
+		}, stick)),
+		vocAboveBaseline: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_voc_above_baseline_ppm",
+			Unit: "ppm",
+			Help: "Calibrated VOC concentration above the clean-air baseline in ppm, with -baseline-half-life.",
+		}, stick)),
+		vocHistogram: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "airsensor_voc_ppm_distribution",
+			Help:    "Distribution of valid VOC values in ppm CO2-equivalent.",
+			Buckets: buckets,
+		}, stick)),
+		temperature: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_temperature_celsius",
+			Unit: "celsius",
+			Help: "Last on-chip temperature in °C, with -temperature-offset. Experimental.",
+		}, stick)),
+		readErrors: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airsensor_read_errors_total",
+			Help: "Number of failed or out-of-range sensor reads by reason.",
+		}, append(stick, "reason"))),
+		anomalies: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airsensor_anomalies_total",
+			Help: "Number of readings deviating by more than -anomaly-sigma standard deviations from the recent mean.",
+		}, stick)),
+		validRatio: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_valid_ratio",
+			Help: "Fraction of valid readings among the last -stats-size reads.",
+		}, stick)),
+		resets: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airsensor_resets_total",
+			Help: "Number of attempts to reset a stick after consecutive bad frames by result.",
+		}, append(stick, "result"))),
+		breakerOpen: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_breaker_open",
+			Help: "1 while the circuit breaker of a stick is open and reads are skipped, 0 otherwise.",
+		}, stick)),
+		pushFailures: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airsensor_push_failures_total",
+			Help: "Number of failed pushes to the Pushgateway by stick serial.",
+		}, []string{"serial"})),
+		sinkBuffered: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_sink_buffered_readings",
+			Help: "Number of readings waiting to be delivered to an output by sink.",
+		}, []string{"sink"})),
+		sinkDropped: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airsensor_sink_dropped_readings_total",
+			Help: "Number of undelivered readings dropped from the full buffer of an output, or on shutdown, by sink.",
+		}, []string{"sink"})),
+		buildInfo: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airsensor_build_info",
+			Help: "Always 1, labelled with the version, commit and build date of the daemon.",
+		}, []string{"version", "commit", "date", "goversion"})),
+	}
+	b := buildInfo()
+	mx.buildInfo.WithLabelValues(b.Version, b.Commit, b.Date, b.GoVersion).Set(1)
+	return mx
+}
+
+// metricsHandler serves the metrics of reg in the Prometheus text format,
+// or as OpenMetrics, including units and _created samples of the
+// counters, when the scraper asks for it in its Accept header.
+func metricsHandler(reg *prometheus.Registry) http.Handler {
+	return promhttp.InstrumentMetricHandler(reg,
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{
+			EnableOpenMetrics:                   true,
+			EnableOpenMetricsTextCreatedSamples: true,
+		}))
+}
+
+// observe updates the metrics with the outcome of a read of the stick with
+// the given device ID and serial.
+func (mx *metrics) observe(device, serial string, r *airsensor.Reading, err error) {
+	if err == nil {
+		err = r.Err()
+	}
+	if err != nil {
+		mx.readErrors.WithLabelValues(device, serial, cfg.Names.name(serial), airsensor.Reason(err)).Inc()
+		return
+	}
+	mx.voc.WithLabelValues(device, serial, cfg.Names.name(serial)).Set(float64(r.VOC))
+	mx.vocCalibrated.WithLabelValues(device, serial, cfg.Names.name(serial)).Set(r.Calibrated)
+	mx.vocSmoothed.WithLabelValues(device, serial, cfg.Names.name(serial)).Set(r.Smoothed)
+	mx.vocHistogram.WithLabelValues(device, serial, cfg.Names.name(serial)).Observe(float64(r.VOC))
+	if cfg.BaselineHalfLife > 0 {
+		mx.vocAboveBaseline.WithLabelValues(device, serial, cfg.Names.name(serial)).Set(r.AboveBaseline)
+	}
+	if r.Anomalous {
+		mx.anomalies.WithLabelValues(device, serial, cfg.Names.name(serial)).Inc()
+	}
+	if r.TemperatureC != nil {
+		mx.temperature.WithLabelValues(device, serial, cfg.Names.name(serial)).Set(*r.TemperatureC)
+	}
+}
+
+// forget drops the metrics of the stick with the given serial, e.g. after
+// it was unplugged.
+func (mx *metrics) forget(serial string) {
+	labels := prometheus.Labels{"serial": serial}
+	for _, vec := range []interface {
+		DeletePartialMatch(prometheus.Labels) int
+	}{mx.voc, mx.vocCalibrated, mx.vocSmoothed, mx.vocAboveBaseline, mx.vocHistogram, mx.temperature, mx.readErrors, mx.anomalies, mx.validRatio, mx.resets, mx.breakerOpen, mx.pushFailures} {
+		vec.DeletePartialMatch(labels)
+	}
+}
+
+// observeValidRatio updates the valid ratio of a stick.
+func (mx *metrics) observeValidRatio(device, serial string, ratio float64) {
+	mx.validRatio.WithLabelValues(device, serial, cfg.Names.name(serial)).Set(ratio)
+}
+
+// observeReset counts a reset attempt of a stick.
+func (mx *metrics) observeReset(device, serial string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	mx.resets.WithLabelValues(device, serial, cfg.Names.name(serial), result).Inc()
+}
+
+// observeBreaker updates the breaker state of a stick.
+func (mx *metrics) observeBreaker(device, serial string, st airsensor.BreakerState) {
+	v := 0.0
+	if st == airsensor.BreakerOpen {
+		v = 1
+	}
+	mx.breakerOpen.WithLabelValues(device, serial, cfg.Names.name(serial)).Set(v)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	"os"
+)
+
+// mqttPublisher publishes valid readings to an MQTT broker.
+type mqttPublisher struct {
+	client mqtt.Client
+	topic  string
+	qos    byte
+}
+
+type mqttMessage struct {
+	VOC       int16    `json:"voc_ppm"`
+	Unit      string   `json:"unit"`
+	Serial    string   `json:"serial"`
+	Name      string   `json:"name"`
+	Timestamp jsonTime `json:"ts"`
+}
+
+// newMQTTPublisher connects to broker, authenticating with username and
+// password if set. The client keeps retrying in the background if the
+// broker is unreachable and reconnects whenever the connection drops.
+func newMQTTPublisher(broker, topic string, qos int, username, password string) (*mqttPublisher, error) {
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d, must be 0, 1 or 2", qos)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("could not get hostname for MQTT client ID: %w", err)
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("airsensor-" + host).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			slog.Info("Connected to MQTT broker", "broker", broker)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("Lost connection to MQTT broker", "broker", broker, "err", err)
+		})
+	c := mqtt.NewClient(opts)
+	c.Connect()
+	return &mqttPublisher{client: c, topic: topic, qos: byte(qos)}, nil
+}
+
+// Write sends r to the broker and waits for the broker to acknowledge it
+// as required by the QoS level. Out-of-range readings are skipped.
+func (p *mqttPublisher) Write(ctx context.Context, r *airsensor.Reading) error {
+	if !r.Valid {
+		return nil
+	}
+	payload, err := json.Marshal(mqttMessage{
+		VOC:       r.VOC,
+		Unit:      vocUnit,
+		Serial:    r.Serial,
+		Name:      cfg.Names.name(r.Serial),
+		Timestamp: jsonTime(r.Timestamp),
+	})
+	if err != nil {
+		return fmt.Errorf("encoding MQTT message: %w", err)
+	}
+	t := p.client.Publish(p.topic, p.qos, false, payload)
+	select {
+	case <-t.Done():
+	case <-ctx.Done():
+		return fmt.Errorf("publishing to %s: %w", p.topic, ctx.Err())
+	}
+	if err := t.Error(); err != nil {
+		return fmt.Errorf("publishing to %s: %w", p.topic, err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker, waiting a moment for pending
+// messages.
+func (p *mqttPublisher) Close() error {
+	p.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// nameMap gives sticks friendly names like "office", keyed by their ID,
+// the serial or bus:address pair. As a flag it is a comma-separated list
+// of id=name pairs; in the YAML file it is a mapping.
+type nameMap map[string]string
+
+func (m nameMap) String() string {
+	pairs := make([]string, 0, len(m))
+	for id, name := range m {
+		pairs = append(pairs, id+"="+name)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set replaces the map with the pairs in s.
+func (m *nameMap) Set(s string) error {
+	names := make(nameMap)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		id, name, ok := strings.Cut(pair, "=")
+		if !ok || id == "" || name == "" {
+			return fmt.Errorf("invalid name %q, must be id=name", pair)
+		}
+		names[id] = name
+	}
+	*m = names
+	return nil
+}
+
+// name returns the friendly name of the stick with the given ID, or the
+// ID itself if it has none.
+func (m nameMap) name(id string) string {
+	if name, ok := m[id]; ok {
+		return name
+	}
+	return id
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// streamNDJSON reads the first stick accepted by sel every interval and
+// prints each reading to stdout as a compact JSON object on a line of its
+// own, shaped like the /voc response. Failed reads are only logged, on
+// stderr. It runs until interrupted and returns the exit code: 0 when
+// stopped by a signal, 1 if the stick can't be opened or stdout fails.
+func streamNDJSON(mx *metrics, interval time.Duration, jitter float64, sel []airsensor.Selector) int {
+	s, err := openOptions().Open(sel...)
+	if err != nil {
+		slog.Error("Could not open sensor", "err", err)
+		return 1
+	}
+	configure(s, mx)
+	srv := newServer(s, mx, s.Info().ID(), s.Info().Device().String(), cfg.EMAAlpha, 0, 0, cfg.HoldLastValid, airsensor.Anomaly{Sigma: cfg.AnomalySigma, Window: cfg.AnomalyWindow}, airsensor.Baseline{HalfLife: cfg.BaselineHalfLife})
+	defer srv.sensor.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		next := time.Now().Add(jittered(interval, jitter))
+		r, err := srv.read()
+		if err != nil {
+			logReadError(srv.serial, err)
+		}
+		if r != nil && (err == nil || r.Valid) {
+			if err := enc.Encode(newVOCResponse(r)); err != nil {
+				slog.Error("Writing to stdout failed", "err", err)
+				return 1
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gonium/goairsensor/airsensor"
+	"os"
+)
+
+type onceResult struct {
+	VOC       int16     `json:"voc_ppm"`
+	Timestamp *jsonTime `json:"ts,omitempty"`
+	Valid     bool      `json:"valid"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// readOnce takes a single reading and prints it to stdout as JSON. It
+// returns the exit code: 0 for a valid reading, 1 otherwise.
+func readOnce(sel []airsensor.Selector) int {
+	var res onceResult
+	defer json.NewEncoder(os.Stdout).Encode(&res)
+
+	s, err := openOptions().Open(sel...)
+	if err != nil {
+		res.Error = err.Error()
+		return 1
+	}
+	defer s.Close()
+	configure(s, nil)
+	s.SetDebug(0)
+
+	r, err := readPrimed(s, s.Info().ID())
+	if err != nil {
+		res.Error = err.Error()
+		return 1
+	}
+	res.VOC, res.Timestamp, res.Valid = r.VOC, jsonTimePtr(&r.Timestamp), r.Valid
+	if !r.Valid {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// object is a JSON object of the OpenAPI document.
+type object map[string]interface{}
+
+// openAPISchemas are the types documented as named schemas. Their schemas
+// are derived from the Go types, so the document follows the responses.
+var openAPISchemas = []struct {
+	name string
+	v    interface{}
+}{
+	{"Reading", vocResponse{}},
+	{"VirtualReading", virtualResponse{}},
+	{"Stats", statsResponse{}},
+	{"Health", managerHealthResponse{}},
+	{"SensorHealth", healthResponse{}},
+	{"HistoryRow", historyRow{}},
+	{"DebugLevel", debugLevelResponse{}},
+	{"USBDevice", usbDevice{}},
+	{"GrafanaQuery", grafanaQuery{}},
+	{"GrafanaSeries", grafanaSeries{}},
+	{"Version", versionResponse{}},
+	{"Error", errorResponse{}},
+}
+
+// schemaGen derives JSON schemas from Go types the way encoding/json
+// marshals them.
+type schemaGen struct {
+	names map[reflect.Type]string
+}
+
+// schema refers to the named schema of t, or describes t inline.
+func (g *schemaGen) schema(t reflect.Type) object {
+	if name, ok := g.names[t]; ok {
+		return object{"$ref": "#/components/schemas/" + name}
+	}
+	return g.inline(t)
+}
+
+func (g *schemaGen) inline(t reflect.Type) object {
+	if t == reflect.TypeOf(time.Time{}) {
+		return object{"type": "string", "format": "date-time"}
+	}
+	if t == reflect.TypeOf(jsonTime{}) {
+		if tsFormat == formatRFC3339 {
+			return object{"type": "string", "format": "date-time"}
+		}
+		return object{"type": "integer", "format": "int64"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.inline(t.Elem())
+		s["nullable"] = true
+		return s
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Map:
+		return object{"type": "object"}
+	case reflect.Slice, reflect.Array:
+		s := object{"type": "array", "items": g.schema(t.Elem())}
+		if t.Kind() == reflect.Array {
+			s["minItems"], s["maxItems"] = t.Len(), t.Len()
+		}
+		return s
+	case reflect.Struct:
+		props := object{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = g.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := object{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	default:
+		return object{}
+	}
+}
+
+// queryParam describes an optional query parameter.
+func queryParam(name, typ, desc string) object {
+	return object{"name": name, "in": "query", "description": desc, "schema": object{"type": typ}}
+}
+
+var deviceParam = queryParam("device", "string", "Serial of the stick, by default the first one opened")
+
+var vocDeviceParam = queryParam("device", "string", "Serial of the stick or name of a virtual sensor, by default the first stick opened")
+
+// jsonResponse describes a JSON response with the named schema, or an
+// array of them.
+func jsonResponse(desc, schema string, array bool) object {
+	s := object{"$ref": "#/components/schemas/" + schema}
+	if array {
+		s = object{"type": "array", "items": s}
+	}
+	return object{"description": desc, "content": object{"application/json": object{"schema": s}}}
+}
+
+// errorResponses describes the error responses with the given status
+// codes.
+func errorResponses(codes ...int) object {
+	resps := object{}
+	for _, code := range codes {
+		resps[strconv.Itoa(code)] = jsonResponse(http.StatusText(code), "Error", false)
+	}
+	return resps
+}
+
+// with adds the responses in more to resps.
+func with(resps object, more object) object {
+	for k, v := range more {
+		resps[k] = v
+	}
+	return resps
+}
+
+// openAPIDocument describes the HTTP API as an OpenAPI 3 document.
+func openAPIDocument() object {
+	g := &schemaGen{names: make(map[reflect.Type]string)}
+	for _, s := range openAPISchemas {
+		g.names[reflect.TypeOf(s.v)] = s.name
+	}
+	schemas := object{}
+	for _, s := range openAPISchemas {
+		schemas[s.name] = g.inline(reflect.TypeOf(s.v))
+	}
+
+	readingResponses := with(object{"200": jsonResponse("The reading", "Reading", false)},
+		errorResponses(404, 502, 503, 504))
+	paths := object{
+		"/voc": object{"get": object{
+			"summary":    "Read the VOC concentration, at most once per -min-read-interval",
+			"parameters": []object{vocDeviceParam},
+			"responses": with(object{"200": object{
+				"description": "The reading, combined from several sticks for a virtual sensor",
+				"content": object{"application/json": object{"schema": object{"oneOf": []object{
+					{"$ref": "#/components/schemas/Reading"},
+					{"$ref": "#/components/schemas/VirtualReading"},
+				}}}},
+			}}, errorResponses(404, 502, 503, 504)),
+		}},
+		"/voc/{serial}": object{"get": object{
+			"summary": "Read the VOC concentration of a stick",
+			"parameters": []object{{
+				"name": "serial", "in": "path", "required": true,
+				"schema": object{"type": "string"},
+			}},
+			"responses": readingResponses,
+		}},
+		"/read": object{"post": object{
+			"summary":    "Take a fresh reading, waiting out -min-read-interval since the last read",
+			"parameters": []object{deviceParam},
+			"responses": with(object{"200": jsonResponse("The reading", "Reading", false)},
+				errorResponses(404, 502, 503, 504)),
+		}},
+		"/stats": object{"get": object{
+			"summary": "Summarize the valid readings of a window",
+			"parameters": []object{
+				queryParam("window", "string", "Go duration, e.g. 1h; default 5m"),
+				deviceParam,
+			},
+			"responses": with(object{"200": jsonResponse("The statistics", "Stats", false)},
+				errorResponses(400, 404)),
+		}},
+		"/history": object{"get": object{
+			"summary": "Readings stored in SQLite, oldest first; only with -sqlite",
+			"parameters": []object{
+				queryParam("from", "string", "RFC 3339 time, default 24 hours before to"),
+				queryParam("to", "string", "RFC 3339 time, default now"),
+				queryParam("device", "string", "Serial of the stick, by default all"),
+			},
+			"responses": with(object{"200": jsonResponse("The readings", "HistoryRow", true)},
+				errorResponses(400, 500)),
+		}},
+		"/healthz": object{"get": object{
+			"summary":    "Report whether the sticks are attached and their circuit breakers closed",
+			"parameters": []object{queryParam("device", "string", "Serial of the stick, by default all")},
+			"responses": with(object{
+				"200": jsonResponse("All sticks are alive", "Health", false),
+				"503": jsonResponse("A stick is gone or its breaker open", "Health", false),
+			}, errorResponses(404)),
+		}},
+		"/readyz": object{"get": object{
+			"summary":    "Report whether the sticks took a valid reading after warm-up recently",
+			"parameters": []object{queryParam("device", "string", "Serial of the stick, by default all")},
+			"responses": with(object{
+				"200": jsonResponse("All sticks are ready", "Health", false),
+				"503": jsonResponse("A stick is not ready", "Health", false),
+			}, errorResponses(404)),
+		}},
+		"/metrics": object{"get": object{
+			"summary": "Prometheus metrics",
+			"responses": object{"200": object{
+				"description": "Metrics in the Prometheus text format or OpenMetrics",
+				"content":     object{"text/plain": object{"schema": object{"type": "string"}}},
+			}},
+		}},
+		"/stream": object{"get": object{
+			"summary":   "WebSocket pushing every polled reading as a Reading",
+			"responses": object{"101": object{"description": "Switching to the WebSocket protocol"}},
+		}},
+		"/search": object{"post": object{
+			"summary": "Targets of the Grafana JSON datasource",
+			"responses": object{"200": object{
+				"description": "The targets",
+				"content": object{"application/json": object{"schema": object{
+					"type": "array", "items": object{"type": "string"},
+				}}},
+			}},
+		}},
+		"/query": object{"post": object{
+			"summary": "Time series of the Grafana JSON datasource",
+			"requestBody": object{"required": true, "content": object{"application/json": object{
+				"schema": object{"$ref": "#/components/schemas/GrafanaQuery"},
+			}}},
+			"responses": with(object{"200": jsonResponse("The series", "GrafanaSeries", true)},
+				errorResponses(400, 404, 500)),
+		}},
+		"/version": object{"get": object{
+			"summary":   "Version and build information of the daemon",
+			"responses": object{"200": jsonResponse("The build information", "Version", false)},
+		}},
+		"/config": object{"get": object{
+			"summary": "Effective settings by -config-file key, with secrets redacted",
+			"responses": with(object{"200": object{
+				"description": "The settings",
+				"content":     object{"application/json": object{"schema": object{"type": "object"}}},
+			}}, errorResponses(500)),
+		}},
+		"/debug/usb": object{"get": object{
+			"summary":   "USB descriptors of the connected sticks",
+			"responses": with(object{"200": jsonResponse("The descriptors", "USBDevice", true)}, errorResponses(503)),
+		}},
+		"/debug/level": object{
+			"get": object{
+				"summary":   "Current libusb debug level",
+				"responses": object{"200": jsonResponse("The level", "DebugLevel", false)},
+			},
+			"post": object{
+				"summary":    "Change the libusb debug level of all sticks",
+				"parameters": []object{queryParam("level", "integer", "0 (silent) to 4 (debug)")},
+				"responses": with(object{"200": jsonResponse("The new level", "DebugLevel", false)},
+					errorResponses(400)),
+			},
+		},
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "airsensor_httpd",
+			"description": "VOC readings of AppliedSensor iAQ-Sticks in " + vocUnit,
+			"version":     "1",
+		},
+		"paths":      paths,
+		"components": object{"schemas": schemas},
+	}
+}
+
+// openAPIHandler serves the OpenAPI document.
+func openAPIHandler() http.HandlerFunc {
+	doc := openAPIDocument()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowGet(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, doc)
+	}
+}
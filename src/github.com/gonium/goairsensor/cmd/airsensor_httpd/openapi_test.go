@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"github.com/gonium/goairsensor/airsensor"
+	"github.com/google/gousb"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// documented returns the status codes the OpenAPI document lists for
+// method on path.
+func documented(t *testing.T, doc object, path, method string) map[string]bool {
+	t.Helper()
+	op, ok := doc["paths"].(object)[path].(object)[method].(object)
+	if !ok {
+		t.Fatalf("%s %s is not documented", method, path)
+	}
+	codes := make(map[string]bool)
+	for code := range op["responses"].(object) {
+		codes[code] = true
+	}
+	return codes
+}
+
+// TestOpenAPIStatuses checks that every status the handlers answer with
+// on the paths the other tests take is documented.
+func TestOpenAPIStatuses(t *testing.T) {
+	doc := openAPIDocument()
+
+	type request struct {
+		name, path, method, target string
+		h                          http.HandlerFunc
+		// prepare runs before the request.
+		prepare func()
+	}
+	var requests []request
+	for _, res := range []airsensor.MockResult{
+		{VOC: 800},
+		{VOC: 3000},
+		{Err: airsensor.ErrReadTimeout},
+		{Err: airsensor.ErrBadFrame},
+		{Err: airsensor.ErrDeviceNotFound},
+		{Err: errors.New("boom")},
+	} {
+		name := airsensor.Reason(res.Err)
+		if res.Err == nil {
+			name = strconv.Itoa(int(res.VOC))
+		}
+		m, _ := newTestManager(t, res, res)
+		requests = append(requests,
+			request{name: "voc " + name, path: "/voc", method: "get", target: "/voc", h: m.handleVOC},
+			request{name: "read " + name, path: "/read", method: "post", target: "/read", h: m.handleRead},
+		)
+	}
+
+	m, srv := newTestManager(t, airsensor.MockResult{Err: airsensor.ErrDeviceNotFound}, airsensor.MockResult{VOC: 800})
+	st, err := openSQLiteStore(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	requests = append(requests,
+		request{name: "voc by serial", path: "/voc/{serial}", method: "get", target: "/voc/1234", h: m.handleVOC},
+		request{name: "voc unknown stick", path: "/voc/{serial}", method: "get", target: "/voc/nope", h: m.handleVOC},
+		request{name: "read unknown stick", path: "/read", method: "post", target: "/read?device=nope", h: m.handleRead},
+		request{name: "stats", path: "/stats", method: "get", target: "/stats", h: m.handleStats},
+		request{name: "stats invalid window", path: "/stats", method: "get", target: "/stats?window=bad", h: m.handleStats},
+		request{name: "stats unknown stick", path: "/stats", method: "get", target: "/stats?device=nope", h: m.handleStats},
+		request{name: "healthz", path: "/healthz", method: "get", target: "/healthz", h: m.healthzHandler()},
+		request{name: "readyz", path: "/readyz", method: "get", target: "/readyz", h: m.readyzHandler(0)},
+		request{name: "healthz unplugged", path: "/healthz", method: "get", target: "/healthz", h: m.healthzHandler(), prepare: func() { srv.read() }},
+		request{name: "readyz ready", path: "/readyz", method: "get", target: "/readyz", h: m.readyzHandler(0), prepare: func() { srv.read() }},
+		request{name: "healthz unknown stick", path: "/healthz", method: "get", target: "/healthz?device=nope", h: m.healthzHandler()},
+		request{name: "readyz unknown stick", path: "/readyz", method: "get", target: "/readyz?device=nope", h: m.readyzHandler(0)},
+		request{name: "history", path: "/history", method: "get", target: "/history", h: st.handleHistory},
+		request{name: "history invalid from", path: "/history", method: "get", target: "/history?from=bad", h: st.handleHistory},
+		request{name: "debug level", path: "/debug/level", method: "get", target: "/debug/level", h: m.handleDebugLevel},
+		request{name: "debug level invalid", path: "/debug/level", method: "post", target: "/debug/level?level=9", h: m.handleDebugLevel},
+		request{name: "config", path: "/config", method: "get", target: "/config", h: handleConfig},
+		request{name: "version", path: "/version", method: "get", target: "/version", h: handleVersion},
+		request{name: "debug usb", path: "/debug/usb", method: "get", target: "/debug/usb", h: handleDebugUSB},
+		request{name: "debug usb failing", path: "/debug/usb", method: "get", target: "/debug/usb", h: handleDebugUSB, prepare: func() {
+			usbDescriptors = func() ([]*gousb.DeviceDesc, error) { return nil, errors.New("enumeration failed") }
+		}},
+	)
+	defer func() { usbDescriptors = airsensor.Descriptors }()
+
+	for _, req := range requests {
+		if req.prepare != nil {
+			req.prepare()
+		}
+		rec := httptest.NewRecorder()
+		req.h(rec, httptest.NewRequest(strings.ToUpper(req.method), req.target, nil))
+		if !documented(t, doc, req.path, req.method)[strconv.Itoa(rec.Code)] {
+			t.Errorf("%s: %s %s answered %d, which isn't documented for %s", req.name, req.method, req.target, rec.Code, req.path)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"math/rand"
+	"time"
+)
+
+// printReading prints a timestamped line for every valid reading.
+func printReading(_ context.Context, r *airsensor.Reading) error {
+	if !r.Valid {
+		return nil
+	}
+	_, err := fmt.Printf("%s %s %d %s\n", formatTime(r.Timestamp, time.RFC3339), r.Serial, r.VOC, vocUnit)
+	return err
+}
+
+// jittered returns interval shifted randomly by up to ±jitter of itself.
+func jittered(interval time.Duration, jitter float64) time.Duration {
+	if jitter == 0 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// poll reads the sensor every interval and writes successful reads to all
+// sinks, see writeAll. A failed read is logged and retried on the next
+// tick. Sinks get until the next tick to take a reading. Each tick is
+// shifted by a fresh jitter, so sticks started together drift apart. It
+// returns once the server is stopped.
+func (srv *server) poll(interval time.Duration, jitter float64, sinks []namedSink) {
+	for {
+		next := time.Now().Add(jittered(interval, jitter))
+		r, err := srv.read()
+		if err != nil {
+			logReadError(srv.serial, err)
+		}
+		if r != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			writeAll(ctx, sinks, r)
+			cancel()
+		}
+		select {
+		case <-srv.done:
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+)
+
+// servePprof serves the profiling handlers, which net/http/pprof
+// registers on DefaultServeMux, on their own address. They are kept off
+// the API listener, so they are neither exposed with it nor behind its
+// authentication.
+func servePprof(addr string) {
+	slog.Info("Serving pprof", "addr", addr)
+	if err := http.ListenAndServe(addr, http.DefaultServeMux); err != nil {
+		slog.Error("pprof server failed", "err", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// printProtocol prints the layout of the request and response frames for
+// -protocol, as the library sends and parses them.
+func printProtocol() {
+	fmt.Printf("Request, written to OUT endpoint %d (% x):\n\n", airsensor.DefaultOutEndpoint, airsensor.DefaultRequestCommand())
+	printLayout(os.Stdout, airsensor.RequestLayout())
+	fmt.Printf("\nResponse, %d bytes read from IN endpoint %d, optionally followed by a second frame (see -flush):\n\n", airsensor.FrameSize, airsensor.DefaultInEndpoint)
+	printLayout(os.Stdout, airsensor.ResponseLayout(vocParse))
+}
+
+// vocParse is where response frames carry the VOC value, set with
+// -voc-offset and -voc-endian.
+var vocParse = airsensor.DefaultParseConfig
+
+// parseConfig returns the ParseConfig set with -voc-offset and
+// -voc-endian.
+func parseConfig() (airsensor.ParseConfig, error) {
+	pc := airsensor.ParseConfig{VOCOffset: cfg.VOCOffset}
+	switch cfg.VOCEndian {
+	case "little":
+		pc.ByteOrder = binary.LittleEndian
+	case "big":
+		pc.ByteOrder = binary.BigEndian
+	default:
+		return pc, fmt.Errorf("invalid VOC byte order %q, must be little or big", cfg.VOCEndian)
+	}
+	if cfg.VOCOffset < 0 || cfg.VOCOffset+2 > cfg.FrameSize {
+		return pc, fmt.Errorf("invalid VOC offset %d, the value must lie within the %d byte frame", cfg.VOCOffset, cfg.FrameSize)
+	}
+	return pc, nil
+}
+
+// printLayout prints the fields of a frame as a table.
+func printLayout(w io.Writer, fields []airsensor.FrameField) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OFFSET\tSIZE\tCONFIDENCE\tMEANING")
+	for _, f := range fields {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", f.Offset, f.Size, f.Confidence, f.Meaning)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"github.com/gonium/goairsensor/airsensor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"sync"
+)
+
+// pushJob is the job label of the pushed metrics.
+const pushJob = "airsensor"
+
+// pushGlobalInstance is the instance label of the group holding the
+// metrics that belong to no stick, like airsensor_build_info and the Go
+// runtime metrics.
+const pushGlobalInstance = "global"
+
+// pusher pushes the metrics to a Prometheus Pushgateway after every
+// reading, for nodes that can't be scraped. The metrics of a stick are
+// grouped by its serial as instance label, the others under
+// pushGlobalInstance, so every series is pushed once. Pushes replace the
+// metrics of the group.
+type pusher struct {
+	url string
+	g   prometheus.Gatherer
+	mx  *metrics
+
+	mu      sync.Mutex
+	pushers map[string]*push.Pusher
+}
+
+// newPusher returns a pusher pushing the metrics gathered by g, counting
+// failures in mx.
+func newPusher(url string, g prometheus.Gatherer, mx *metrics) *pusher {
+	return &pusher{url: url, g: g, mx: mx, pushers: make(map[string]*push.Pusher)}
+}
+
+// get returns the pusher of the stick with the given serial, or of the
+// global metrics for an empty serial.
+func (p *pusher) get(serial string) *push.Pusher {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ps, ok := p.pushers[serial]
+	if !ok {
+		instance := serial
+		if serial == "" {
+			instance = pushGlobalInstance
+		}
+		ps = push.New(p.url, pushJob).
+			Grouping("instance", instance).
+			Gatherer(gatherSerial(p.g, serial))
+		p.pushers[serial] = ps
+	}
+	return ps
+}
+
+// gatherSerial returns a gatherer of the metrics of g labelled with the
+// given serial, or of those without a serial label for an empty one.
+func gatherSerial(g prometheus.Gatherer, serial string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		var kept []*dto.MetricFamily
+		for _, mf := range mfs {
+			var ms []*dto.Metric
+			for _, m := range mf.GetMetric() {
+				if labelValue(m, "serial") == serial {
+					ms = append(ms, m)
+				}
+			}
+			if len(ms) > 0 {
+				mf.Metric = ms
+				kept = append(kept, mf)
+			}
+		}
+		return kept, err
+	})
+}
+
+// labelValue returns the value of the label with the given name of m,
+// or "" if it has none.
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// Write pushes the metrics of the stick that took r, then the global
+// ones.
+func (p *pusher) Write(ctx context.Context, r *airsensor.Reading) error {
+	err := p.get(r.Serial).PushContext(ctx)
+	if err == nil {
+		err = p.get("").PushContext(ctx)
+	}
+	if err != nil {
+		p.mx.pushFailures.WithLabelValues(r.Serial).Inc()
+		return err
+	}
+	return nil
+}
+
+func (p *pusher) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"github.com/gonium/goairsensor/airsensor"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGatherSerial(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	mx := newMetrics(reg, nil)
+	for _, serial := range []string{"1111", "2222"} {
+		srv := newServer(airsensor.NewMockSensor(airsensor.MockResult{VOC: 800}), mx, serial, "03eb:2013", 0, 16, 0, false, airsensor.Anomaly{}, airsensor.Baseline{})
+		srv.read()
+	}
+
+	for _, serial := range []string{"1111", "2222", ""} {
+		mfs, err := gatherSerial(reg, serial).Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make(map[string]bool)
+		for _, mf := range mfs {
+			names[mf.GetName()] = true
+			for _, m := range mf.GetMetric() {
+				if got := labelValue(m, "serial"); got != serial {
+					t.Errorf("group %q: %s has serial %q", serial, mf.GetName(), got)
+				}
+			}
+		}
+		want := "airsensor_voc_ppm"
+		if serial == "" {
+			want = "airsensor_build_info"
+		}
+		if !names[want] {
+			t.Errorf("group %q: no %s", serial, want)
+		}
+	}
+}
+
+func TestPusher(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	mx := newMetrics(reg, nil)
+	srv := newServer(airsensor.NewMockSensor(airsensor.MockResult{VOC: 800}), mx, "1111", "03eb:2013", 0, 16, 0, false, airsensor.Anomaly{}, airsensor.Baseline{})
+
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gw.Close()
+
+	r, _ := srv.read()
+	r.Serial = "1111"
+	if err := newPusher(gw.URL, reg, mx).Write(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(paths)
+	want := []string{"PUT /metrics/job/airsensor/instance/1111", "PUT /metrics/job/airsensor/instance/global"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("pushes = %v, want %v", paths, want)
+	}
+}
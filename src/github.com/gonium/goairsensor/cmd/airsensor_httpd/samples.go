@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"math"
+	"os"
+	"time"
+)
+
+// readSamples takes n readings back to back, prints one line each and a
+// summary of the valid ones. It returns the exit code: 0 if all n
+// readings were taken, 1 otherwise.
+func readSamples(n int, sel []airsensor.Selector) int {
+	s, err := openOptions().Open(sel...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+	configure(s, nil)
+	s.SetDebug(0)
+
+	start := time.Now()
+	rs, err := s.ReadN(n)
+	took := time.Since(start)
+
+	var sum float64
+	valid := 0
+	for _, r := range rs {
+		fmt.Printf("%s %d %t\n", formatTime(r.Timestamp, time.RFC3339Nano), r.VOC, r.Valid)
+		if r.Valid {
+			sum += float64(r.VOC)
+			valid++
+		}
+	}
+	if valid > 0 {
+		mean := sum / float64(valid)
+		var sq float64
+		for _, r := range rs {
+			if r.Valid {
+				sq += (float64(r.VOC) - mean) * (float64(r.VOC) - mean)
+			}
+		}
+		var stddev float64
+		if valid > 1 {
+			stddev = math.Sqrt(sq / float64(valid-1))
+		}
+		fmt.Printf("%d of %d samples valid in %s, mean %.1f, stddev %.2f %s\n",
+			valid, len(rs), took.Round(time.Millisecond), mean, stddev, vocUnit)
+	} else {
+		fmt.Printf("0 of %d samples valid in %s\n", len(rs), took.Round(time.Millisecond))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
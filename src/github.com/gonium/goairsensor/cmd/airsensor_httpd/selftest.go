@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"strings"
+	"time"
+)
+
+// selftest prints a pass/fail line with timing per step. Once a step
+// fails, the remaining ones are skipped.
+type selftest struct {
+	failed bool
+}
+
+func (t *selftest) step(name string, run func() (string, error)) {
+	if t.failed {
+		fmt.Printf("SKIP  %-14s\n", name)
+		return
+	}
+	start := time.Now()
+	detail, err := run()
+	took := time.Since(start).Round(time.Microsecond)
+	if err != nil {
+		t.failed = true
+		fmt.Printf("FAIL  %-14s %10s  %v\n", name, took, err)
+		return
+	}
+	fmt.Printf("PASS  %-14s %10s  %s\n", name, took, detail)
+}
+
+// runSelftest checks the whole chain from enumerating the sticks to
+// decoding a reading. It returns the exit code: 0 if all steps passed, 1
+// otherwise.
+func runSelftest(sel []airsensor.Selector) int {
+	var t selftest
+	t.step("enumerate", func() (string, error) {
+		infos, err := airsensor.Discover()
+		var found []string
+		for _, info := range infos {
+			if selected(info, sel) {
+				found = append(found, info.String())
+			}
+		}
+		if len(found) == 0 {
+			if err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("no matching %s connected", airsensor.SupportedDevices)
+		}
+		return strings.Join(found, "; "), nil
+	})
+
+	var s *airsensor.Sensor
+	t.step("open and claim", func() (string, error) {
+		var err error
+		s, err = openOptions().Open(sel...)
+		if err != nil {
+			return "", err
+		}
+		configure(s, nil)
+		s.SetDebug(0)
+		serial, _ := s.Serial()
+		firmware, _ := s.FirmwareVersion()
+		return "serial " + serial + ", firmware " + firmware, nil
+	})
+	if s != nil {
+		defer s.Close()
+	}
+
+	var (
+		r   *airsensor.Reading
+		err error
+	)
+	t.step("round trip", func() (string, error) {
+		r, err = s.ReadVOC()
+		if err != nil && !errors.Is(err, airsensor.ErrBadFrame) {
+			return "", err
+		}
+		return "got a response", nil
+	})
+	t.step("frame header", func() (string, error) {
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("% x", r.Raw[:2]), nil
+	})
+	t.step("VOC range", func() (string, error) {
+		if err := r.Err(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d %s", r.VOC, vocUnit), nil
+	})
+
+	if t.failed {
+		fmt.Println("Self-test failed")
+		return 1
+	}
+	fmt.Println("Self-test passed")
+	return 0
+}
+
+// selected reports whether info is accepted by all selectors.
+func selected(info airsensor.SensorInfo, sel []airsensor.Selector) bool {
+	for _, accept := range sel {
+		if !accept(info) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// server owns a sensor and caches the outcome of the latest reads, which
+// are taken both by the poll loop and on demand by /voc.
+type server struct {
+	sensor airsensor.SensorReader
+	serial string
+	// device labels the metrics of the stick, its vendor:product ID.
+	device string
+	// mx records the outcome of every read.
+	mx *metrics
+
+	// minReadInterval is the minimum time between two reads on behalf
+	// of /voc; requests in between get the outcome of the last read.
+	minReadInterval time.Duration
+	// readMu serializes the on-demand reads, so concurrent requests
+	// share one.
+	readMu sync.Mutex
+	// holdLastValid replaces out-of-range readings with the last valid
+	// one.
+	holdLastValid bool
+
+	mu        sync.Mutex
+	ema       airsensor.EMA
+	anomaly   airsensor.Anomaly
+	baseline  airsensor.Baseline
+	agg       *airsensor.Aggregator
+	last      lastRead
+	lastValid *airsensor.Reading
+	lastErr   error
+	lastErrAt time.Time
+	// restored is the last valid reading saved before a restart. It
+	// stands in, marked stale, until a fresh valid reading is taken.
+	restored *airsensor.Reading
+
+	// done is closed by stop to end the poll loop.
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// lastRead is the outcome of the latest read.
+type lastRead struct {
+	r   *airsensor.Reading
+	err error
+	at  time.Time
+}
+
+func newServer(s airsensor.SensorReader, mx *metrics, serial, device string, emaAlpha float64, statsSize int, minReadInterval time.Duration, holdLastValid bool, anomaly airsensor.Anomaly, baseline airsensor.Baseline) *server {
+	return &server{
+		sensor:          s,
+		serial:          serial,
+		device:          device,
+		mx:              mx,
+		minReadInterval: minReadInterval,
+		holdLastValid:   holdLastValid,
+		ema:             airsensor.EMA{Alpha: emaAlpha},
+		anomaly:         anomaly,
+		baseline:        baseline,
+		agg:             airsensor.NewAggregator(statsSize),
+		done:            make(chan struct{}),
+	}
+}
+
+// stop ends the poll loop, e.g. when the stick was unplugged.
+func (srv *server) stop() {
+	srv.stopOnce.Do(func() { close(srv.done) })
+}
+
+// read takes a reading, smooths it, checks it for a spike, compares it to
+// the baseline and records its outcome. Invalid readings don't enter the
+// average; they carry the current average. Neither they nor readings
+// during warm-up enter the anomaly window or the baseline. With
+// holdLastValid, an out-of-range reading is returned as a copy of the last
+// valid one, taken now, along with its error.
+func (srv *server) read() (*airsensor.Reading, error) {
+	r, err := readPrimed(srv.sensor, srv.serial)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if err == nil {
+		if r.Valid {
+			r.Smoothed = srv.ema.Update(r.Calibrated)
+			if !r.Stabilizing {
+				r.Anomalous = srv.anomaly.Update(r.Calibrated)
+				r.AboveBaseline = r.Calibrated - srv.baseline.Update(r.Calibrated, r.Timestamp)
+			} else if v, _, ok := srv.baseline.Value(); ok {
+				r.AboveBaseline = r.Calibrated - v
+			}
+		} else if v, ok := srv.ema.Value(); ok {
+			r.Smoothed = v
+		}
+		srv.agg.Add(r)
+	} else {
+		// A failed read counts as an invalid reading towards the valid
+		// ratio.
+		srv.agg.Add(&airsensor.Reading{Serial: srv.serial, Timestamp: time.Now(), Monotonic: airsensor.Monotonic()})
+	}
+	srv.mx.observe(srv.device, srv.serial, r, err)
+	ratio, _ := srv.agg.ValidRatio()
+	srv.mx.observeValidRatio(srv.device, srv.serial, ratio)
+	if err == nil {
+		err = r.Err()
+	}
+	if err != nil {
+		srv.lastErr, srv.lastErrAt = err, time.Now()
+	} else if !r.Stabilizing {
+		// Readings during warm-up don't count towards health.
+		srv.lastValid, srv.restored = r, nil
+	}
+	if srv.holdLastValid && errors.Is(err, airsensor.ErrValueOutOfRange) && srv.lastValid != nil {
+		held := *srv.lastValid
+		held.Timestamp, held.Monotonic = r.Timestamp, r.Monotonic
+		r = &held
+	}
+	srv.last = lastRead{r: r, err: err, at: time.Now()}
+	return r, err
+}
+
+// readPrimed reads s, and reads it again right away if that was the
+// priming reading after opening, so it never reaches the metrics, the
+// sinks or the API.
+func readPrimed(s airsensor.SensorReader, serial string) (*airsensor.Reading, error) {
+	r, err := s.ReadVOC()
+	if err == nil && r.Priming {
+		slog.Debug("Discarding priming reading", "serial", serial, "voc", r.VOC)
+		r, err = s.ReadVOC()
+	}
+	return r, err
+}
+
+// logReadError logs a failed read. Out-of-range values get messages of
+// their own, so a saturated sensor stands out from a corrupted frame.
+func logReadError(serial string, err error) {
+	switch {
+	case errors.Is(err, airsensor.ErrBreakerOpen):
+		// The breaker logged when it opened.
+		slog.Debug("Skipping read", "serial", serial, "err", err)
+	case errors.Is(err, airsensor.ErrBelowRange):
+		slog.Warn("VOC value below sensor range", "serial", serial, "err", err)
+	case errors.Is(err, airsensor.ErrAboveRange):
+		slog.Warn("VOC value above sensor range, sensor saturated", "serial", serial, "err", err)
+	case errors.Is(err, airsensor.ErrBogusValue):
+		slog.Error("Bogus VOC value, frame probably corrupted", "serial", serial, "err", err)
+	default:
+		slog.Error("Reading sensor failed", "serial", serial, "err", err)
+	}
+}
+
+type vocResponse struct {
+	VOC         int16    `json:"voc_ppm"`
+	Calibrated  float64  `json:"voc_calibrated_ppm"`
+	Smoothed    float64  `json:"voc_smoothed_ppm"`
+	Unit        string   `json:"unit"`
+	Serial      string   `json:"serial"`
+	Name        string   `json:"name"`
+	Timestamp   jsonTime `json:"ts"`
+	Valid       bool     `json:"valid"`
+	Stabilizing bool     `json:"stabilizing"`
+	// Anomalous marks a spike, see -anomaly-sigma.
+	Anomalous bool `json:"anomalous"`
+	// AboveBaseline is the excess over the clean-air baseline, see
+	// -baseline-half-life.
+	AboveBaseline float64 `json:"voc_above_baseline_ppm"`
+	// Stale marks a reading restored from before a restart.
+	Stale bool `json:"stale,omitempty"`
+
+	CO2Equivalent int16   `json:"co2_equivalent_ppm"`
+	TVOC          float64 `json:"tvoc_mg_m3"`
+
+	// Experimental frame fields.
+	Resistance   uint32   `json:"resistance"`
+	Status       byte     `json:"status"`
+	TemperatureC *float64 `json:"temperature_celsius,omitempty"`
+}
+
+func newVOCResponse(r *airsensor.Reading) vocResponse {
+	return vocResponse{
+		VOC:         r.VOC,
+		Calibrated:  r.Calibrated,
+		Smoothed:    r.Smoothed,
+		Unit:        vocUnit,
+		Serial:      r.Serial,
+		Name:        cfg.Names.name(r.Serial),
+		Timestamp:   jsonTime(r.Timestamp),
+		Valid:       r.Valid,
+		Stabilizing: r.Stabilizing,
+		Anomalous:   r.Anomalous,
+
+		AboveBaseline: r.AboveBaseline,
+
+		CO2Equivalent: r.CO2Equivalent(),
+		TVOC:          r.TVOCmgm3(),
+
+		Resistance:   r.Resistance,
+		Status:       r.Status,
+		TemperatureC: r.TemperatureC,
+	}
+}
+
+type statsResponse struct {
+	Serial string  `json:"serial"`
+	Name   string  `json:"name"`
+	Min    int16   `json:"min"`
+	Max    int16   `json:"max"`
+	Avg    float64 `json:"avg"`
+	Count  int     `json:"count"`
+	Window string  `json:"window"`
+	// ValidRatio is the fraction of valid readings among the last
+	// Readings ones, regardless of the window.
+	ValidRatio float64 `json:"valid_ratio"`
+	Readings   int     `json:"readings"`
+}
+
+// stats summarizes the valid readings of the last window.
+func (srv *server) stats(window time.Duration) statsResponse {
+	srv.mu.Lock()
+	st := srv.agg.Stats(window, time.Now())
+	ratio, n := srv.agg.ValidRatio()
+	srv.mu.Unlock()
+	return statsResponse{
+		Serial:     srv.serial,
+		Name:       cfg.Names.name(srv.serial),
+		Min:        st.Min,
+		Max:        st.Max,
+		Avg:        st.Avg,
+		Count:      st.Count,
+		Window:     st.Window.String(),
+		ValidRatio: ratio,
+		Readings:   n,
+	}
+}
+
+// readings returns the kept valid readings taken between from and to,
+// oldest first.
+func (srv *server) readings(from, to time.Time) []airsensor.Reading {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.agg.Readings(from, to, time.Now())
+}
+
+// errorResponse is the body of every error response.
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiError describes what went wrong. Code is stable, so clients can
+// branch on it; Message is for humans and may change.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Detail carries code-specific context, like the offending
+	// parameter.
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+// Codes of errors not caused by a read, see airsensor.Reason for those.
+const (
+	codeUnauthorized     = "unauthorized"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeInvalidParameter = "invalid_parameter"
+	codeNotFound         = "not_found"
+	codeNoValidReading   = "no_valid_reading"
+	codeUnavailable      = "unavailable"
+	codeInternal         = "internal_error"
+)
+
+// writeError writes an error response.
+func writeError(w http.ResponseWriter, status int, code, message string, detail map[string]interface{}) {
+	writeJSON(w, status, errorResponse{Error: apiError{Code: code, Message: message, Detail: detail}})
+}
+
+// writeReadError writes the response to a failed read of the stick with
+// the given serial. The code is the reason label of the read error metric,
+// with "read_failed" standing in for "other".
+func writeReadError(w http.ResponseWriter, serial string, err error) {
+	code := airsensor.Reason(err)
+	if code == "other" {
+		code = "read_failed"
+	}
+	writeError(w, errorStatus(err), code, err.Error(), map[string]interface{}{"serial": serial})
+}
+
+// writeNoSensor writes the response to a request for an unknown stick.
+func writeNoSensor(w http.ResponseWriter, serial string) {
+	writeError(w, http.StatusNotFound, codeNotFound,
+		fmt.Sprintf("no sensor with serial %q", serial), map[string]interface{}{"serial": serial})
+}
+
+// writeInvalidParameter writes the response to a request with an invalid
+// query or form parameter.
+func writeInvalidParameter(w http.ResponseWriter, name, message string) {
+	writeError(w, http.StatusBadRequest, codeInvalidParameter, message, map[string]interface{}{"parameter": name})
+}
+
+// writeMethodNotAllowed writes the response to a request with a method
+// other than allow.
+func writeMethodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed", nil)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to encode response", "err", err)
+	}
+}
+
+// errorStatus maps a read error to an HTTP status code.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, airsensor.ErrReadTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, airsensor.ErrBadFrame):
+		return http.StatusBadGateway
+	default:
+		// ErrDeviceNotFound, ErrValueOutOfRange and anything else
+		// means that there is no usable reading right now.
+		return http.StatusServiceUnavailable
+	}
+}
+
+// allowGet rejects requests with methods other than GET.
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+	writeMethodNotAllowed(w, http.MethodGet)
+	return false
+}
+
+// allowPost rejects requests with methods other than POST.
+func allowPost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost {
+		return true
+	}
+	writeMethodNotAllowed(w, http.MethodPost)
+	return false
+}
+
+// readCached returns the outcome of the last read if it is younger than
+// minReadInterval, along with its age, and reads the sensor otherwise.
+func (srv *server) readCached() (*airsensor.Reading, time.Duration, error) {
+	srv.readMu.Lock()
+	defer srv.readMu.Unlock()
+	srv.mu.Lock()
+	last := srv.last
+	srv.mu.Unlock()
+	if !last.at.IsZero() {
+		if age := time.Since(last.at); age < srv.minReadInterval {
+			return last.r, age, last.err
+		}
+	}
+	r, err := srv.read()
+	return r, 0, err
+}
+
+// readFresh reads the sensor, bypassing the cache. It still waits until
+// minReadInterval has passed since the last read, or ctx is done.
+func (srv *server) readFresh(ctx context.Context) (*airsensor.Reading, error) {
+	srv.readMu.Lock()
+	defer srv.readMu.Unlock()
+	srv.mu.Lock()
+	last := srv.last
+	srv.mu.Unlock()
+	if wait := srv.minReadInterval - time.Since(last.at); !last.at.IsZero() && wait > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	return srv.read()
+}
+
+// handleRead takes a fresh reading on POST requests, see readFresh.
+func (srv *server) handleRead(w http.ResponseWriter, r *http.Request) {
+	if !allowPost(w, r) {
+		return
+	}
+	reading, err := srv.readFresh(r.Context())
+	if r.Context().Err() != nil {
+		// The client went away while waiting.
+		return
+	}
+	if err != nil {
+		logReadError(srv.serial, err)
+		writeReadError(w, srv.serial, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newVOCResponse(reading))
+}
+
+// handleVOC reads the sensor on GET requests, at most once per
+// minReadInterval. A cached outcome is marked with an Age header.
+func (srv *server) handleVOC(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	reading, age, err := srv.readCached()
+	if age > 0 {
+		w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	}
+	if err != nil {
+		logReadError(srv.serial, err)
+		// A held reading stands in for an out-of-range one, and a
+		// restored one for any failure until a fresh read succeeds.
+		if reading == nil || !reading.Valid {
+			srv.mu.Lock()
+			restored := srv.restored
+			srv.mu.Unlock()
+			if restored == nil {
+				writeReadError(w, srv.serial, err)
+				return
+			}
+			resp := newVOCResponse(restored)
+			resp.Stale = true
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+	}
+	slog.Info("VOC concentration", "serial", srv.serial, "voc", reading.VOC, "unit", vocUnit)
+	writeJSON(w, http.StatusOK, newVOCResponse(reading))
+}
+
+type healthResponse struct {
+	Serial    string    `json:"serial"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	LastValid *jsonTime `json:"last_valid,omitempty"`
+	// Breaker is the state of the circuit breaker, if there is one.
+	Breaker string `json:"breaker,omitempty"`
+	// Stale reports that LastValid was restored from before a restart.
+	Stale     bool   `json:"stale,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// status fills in the fields common to the liveness and readiness checks
+// from the cached state, along with the last valid reading taken since the
+// start, the outcome of the last read and whether the circuit breaker is
+// open. LastValid falls back to the restored reading. It never touches the
+// device, so probes don't hammer it.
+func (srv *server) status() (resp healthResponse, lastValid *airsensor.Reading, lastErr error, breakerOpen bool) {
+	srv.mu.Lock()
+	resp = healthResponse{Serial: srv.serial, Name: cfg.Names.name(srv.serial)}
+	lastValid = srv.lastValid
+	shown := lastValid
+	if shown == nil && srv.restored != nil {
+		shown, resp.Stale = srv.restored, true
+	}
+	if srv.lastErr != nil {
+		resp.LastError = srv.lastErr.Error()
+	}
+	lastErr = srv.last.err
+	srv.mu.Unlock()
+
+	if shown != nil {
+		resp.LastValid = jsonTimePtr(&shown.Timestamp)
+	}
+	if b, ok := srv.sensor.(*airsensor.Breaker); ok {
+		st := b.State()
+		resp.Breaker, breakerOpen = st.String(), st == airsensor.BreakerOpen
+	}
+	return resp, lastValid, lastErr, breakerOpen
+}
+
+// live reports healthy unless the stick is gone or its circuit breaker is
+// open. Warm-up and failed reads of a stick still answering don't count.
+func (srv *server) live() healthResponse {
+	resp, _, lastErr, breakerOpen := srv.status()
+	switch {
+	case breakerOpen:
+		resp.Reason = "circuit breaker open after consecutive failed reads"
+	case errors.Is(lastErr, airsensor.ErrDeviceNotFound) || airsensor.IsDisconnected(lastErr):
+		resp.Reason = "USB device unavailable"
+	default:
+		resp.Status = "ok"
+		return resp
+	}
+	resp.Status = "unhealthy"
+	return resp
+}
+
+// ready reports ready if a valid reading was taken after warm-up within
+// the last staleAfter. One restored from before a restart doesn't count,
+// the stick has to answer first. The age is taken on the monotonic clock, so a step of the wall clock doesn't
+// make a fresh reading look stale.
+func (srv *server) ready(staleAfter time.Duration) healthResponse {
+	resp, lastValid, _, breakerOpen := srv.status()
+	switch {
+	case breakerOpen:
+		resp.Reason = "circuit breaker open after consecutive failed reads"
+	case lastValid == nil:
+		resp.Reason = "no valid reading after warm-up yet"
+	case staleAfter > 0 && lastValid.Age() > staleAfter:
+		resp.Reason = fmt.Sprintf("last valid reading is older than %s", staleAfter)
+	default:
+		resp.Status = "ok"
+		return resp
+	}
+	resp.Status = "unhealthy"
+	return resp
+}
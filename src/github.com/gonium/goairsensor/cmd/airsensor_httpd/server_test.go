@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a manager with a single stick, serial 1234,
+// replaying results.
+func newTestManager(t *testing.T, results ...airsensor.MockResult) (*SensorManager, *server) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	mx := newMetrics(reg, nil)
+	m := newSensorManager(reg, mx)
+	srv := newServer(airsensor.NewMockSensor(results...), mx, "1234", "03eb:2013", 0, 16, 0, false, airsensor.Anomaly{}, airsensor.Baseline{})
+	if err := m.add(srv); err != nil {
+		t.Fatal(err)
+	}
+	return m, srv
+}
+
+// serve sends a request to h and returns the status and the decoded body.
+func serve(t *testing.T, h http.HandlerFunc, method, target string) (int, map[string]interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(method, target, nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: decoding %q: %v", method, target, rec.Body, err)
+	}
+	return rec.Code, body
+}
+
+// errorCode returns the code of an error response.
+func errorCode(body map[string]interface{}) string {
+	e, _ := body["error"].(map[string]interface{})
+	code, _ := e["code"].(string)
+	return code
+}
+
+func TestHandleVOC(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     airsensor.MockResult
+		target     string
+		method     string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "valid", result: airsensor.MockResult{VOC: 800}, wantStatus: http.StatusOK},
+		{name: "by serial", result: airsensor.MockResult{VOC: 800}, target: "/voc/1234", wantStatus: http.StatusOK},
+		{name: "by query", result: airsensor.MockResult{VOC: 800}, target: "/voc?device=1234", wantStatus: http.StatusOK},
+		{name: "above range", result: airsensor.MockResult{VOC: 3000}, wantStatus: http.StatusServiceUnavailable, wantCode: "above_range"},
+		{name: "below range", result: airsensor.MockResult{VOC: 400}, wantStatus: http.StatusServiceUnavailable, wantCode: "below_range"},
+		{name: "timeout", result: airsensor.MockResult{Err: airsensor.ErrReadTimeout}, wantStatus: http.StatusGatewayTimeout, wantCode: "read_timeout"},
+		{name: "bad frame", result: airsensor.MockResult{Err: airsensor.ErrBadFrame}, wantStatus: http.StatusBadGateway, wantCode: "bad_frame"},
+		{name: "gone", result: airsensor.MockResult{Err: airsensor.ErrDeviceNotFound}, wantStatus: http.StatusServiceUnavailable, wantCode: "device_not_found"},
+		{name: "other", result: airsensor.MockResult{Err: fmt.Errorf("boom")}, wantStatus: http.StatusServiceUnavailable, wantCode: "read_failed"},
+		{name: "unknown stick", result: airsensor.MockResult{VOC: 800}, target: "/voc/nope", wantStatus: http.StatusNotFound, wantCode: codeNotFound},
+		{name: "post", result: airsensor.MockResult{VOC: 800}, method: http.MethodPost, wantStatus: http.StatusMethodNotAllowed, wantCode: codeMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t, tt.result)
+			target, method := tt.target, tt.method
+			if target == "" {
+				target = "/voc"
+			}
+			if method == "" {
+				method = http.MethodGet
+			}
+			status, body := serve(t, m.handleVOC, method, target)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d (%v)", status, tt.wantStatus, body)
+			}
+			if code := errorCode(body); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if body["voc_ppm"] != float64(tt.result.VOC) || body["valid"] != true {
+					t.Errorf("body = %v, want a valid reading of %d ppm", body, tt.result.VOC)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleVOCCaches(t *testing.T) {
+	m, srv := newTestManager(t, airsensor.MockResult{VOC: 800}, airsensor.MockResult{VOC: 900})
+	srv.minReadInterval = 50 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		status, body := serve(t, m.handleVOC, http.MethodGet, "/voc")
+		if status != http.StatusOK || body["voc_ppm"] != 800.0 {
+			t.Errorf("request %d: %d %v, want the first reading", i, status, body)
+		}
+	}
+	// A fresh read bypasses the cache, once minReadInterval has passed.
+	status, body := serve(t, m.handleRead, http.MethodPost, "/read")
+	if status != http.StatusOK || body["voc_ppm"] != 900.0 {
+		t.Errorf("POST /read: %d %v, want the second reading", status, body)
+	}
+}
+
+func TestHandleRead(t *testing.T) {
+	m, _ := newTestManager(t, airsensor.MockResult{VOC: 800}, airsensor.MockResult{Err: airsensor.ErrReadTimeout})
+
+	if status, body := serve(t, m.handleRead, http.MethodGet, "/read"); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET: %d %v, want %d", status, body, http.StatusMethodNotAllowed)
+	}
+	if status, body := serve(t, m.handleRead, http.MethodPost, "/read"); status != http.StatusOK || body["voc_ppm"] != 800.0 {
+		t.Errorf("POST: %d %v, want 800 ppm", status, body)
+	}
+	if status, body := serve(t, m.handleRead, http.MethodPost, "/read"); status != http.StatusGatewayTimeout || errorCode(body) != "read_timeout" {
+		t.Errorf("POST after timeout: %d %v, want %d read_timeout", status, body, http.StatusGatewayTimeout)
+	}
+	if status, body := serve(t, m.handleRead, http.MethodPost, "/read?device=nope"); status != http.StatusNotFound {
+		t.Errorf("POST unknown stick: %d %v, want %d", status, body, http.StatusNotFound)
+	}
+}
+
+func TestHandleStats(t *testing.T) {
+	m, srv := newTestManager(t, airsensor.MockResult{VOC: 600}, airsensor.MockResult{VOC: 3000}, airsensor.MockResult{VOC: 1000})
+	for i := 0; i < 3; i++ {
+		srv.read()
+	}
+
+	status, body := serve(t, m.handleStats, http.MethodGet, "/stats")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want %d (%v)", status, http.StatusOK, body)
+	}
+	if body["count"] != 2.0 || body["min"] != 600.0 || body["max"] != 1000.0 || body["avg"] != 800.0 {
+		t.Errorf("stats = %v, want 2 readings from 600 to 1000 averaging 800", body)
+	}
+	if body["readings"] != 3.0 || body["valid_ratio"] != 2.0/3 {
+		t.Errorf("stats = %v, want a valid ratio of 2/3 over 3 readings", body)
+	}
+
+	for _, target := range []string{"/stats?window=bad", "/stats?window=-1m", "/stats?window=0s"} {
+		status, body := serve(t, m.handleStats, http.MethodGet, target)
+		if status != http.StatusBadRequest || errorCode(body) != codeInvalidParameter {
+			t.Errorf("%s: %d %v, want %d %s", target, status, body, http.StatusBadRequest, codeInvalidParameter)
+		}
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	m, srv := newTestManager(t, airsensor.MockResult{Err: airsensor.ErrReadTimeout}, airsensor.MockResult{VOC: 800}, airsensor.MockResult{Err: airsensor.ErrDeviceNotFound})
+	healthz, readyz := m.healthzHandler(), m.readyzHandler(time.Minute)
+
+	check := func(step string, h http.HandlerFunc, want int) {
+		t.Helper()
+		if status, body := serve(t, h, http.MethodGet, "/"); status != want {
+			t.Errorf("%s: %d %v, want %d", step, status, body, want)
+		}
+	}
+	check("healthz before reading", healthz, http.StatusOK)
+	check("readyz before reading", readyz, http.StatusServiceUnavailable)
+	srv.restore(sensorState{Serial: "1234", LastValid: &airsensor.Reading{VOC: 700, Timestamp: time.Now(), Monotonic: airsensor.Monotonic()}})
+	check("readyz after restoring", readyz, http.StatusServiceUnavailable)
+	srv.read()
+	check("healthz after timeout", healthz, http.StatusOK)
+	check("readyz after timeout", readyz, http.StatusServiceUnavailable)
+	srv.read()
+	check("healthz after reading", healthz, http.StatusOK)
+	check("readyz after reading", readyz, http.StatusOK)
+	srv.read()
+	check("healthz after unplugging", healthz, http.StatusServiceUnavailable)
+	check("readyz after unplugging", readyz, http.StatusOK)
+
+	if status, _ := serve(t, healthz, http.MethodGet, "/healthz?device=nope"); status != http.StatusNotFound {
+		t.Errorf("healthz of unknown stick: %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := serve(t, readyz, http.MethodPost, "/readyz"); status != http.StatusMethodNotAllowed {
+		t.Errorf("POST readyz: %d, want %d", status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	_, srv := newTestManager(t,
+		airsensor.MockResult{VOC: 600},
+		airsensor.MockResult{Err: airsensor.ErrReadTimeout},
+		airsensor.MockResult{VOC: 3000},
+		airsensor.MockResult{VOC: 700},
+	)
+
+	var (
+		mu  sync.Mutex
+		got []int16
+	)
+	done := make(chan struct{})
+	sink := sinkFunc(func(_ context.Context, r *airsensor.Reading) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, r.VOC)
+		if len(got) == 3 {
+			close(done)
+		}
+		return nil
+	})
+	stopped := make(chan struct{})
+	go func() {
+		srv.poll(time.Millisecond, 0, []namedSink{{name: "test", Sink: sink}})
+		close(stopped)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the poll loop")
+	}
+	srv.stop()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll loop didn't return after stop")
+	}
+
+	// The failed read doesn't reach the sinks; the out-of-range one does.
+	mu.Lock()
+	defer mu.Unlock()
+	want := []int16{600, 3000, 700}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("sink got %v, want %v", got, want)
+	}
+	if st := srv.stats(time.Minute); st.Count != 2 || st.Min != 600 || st.Max != 700 {
+		t.Errorf("stats = %+v, want 2 valid readings from 600 to 700", st)
+	}
+}
+
+func TestDeviceLabel(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	srv := newServer(airsensor.NewMockSensor(airsensor.MockResult{VOC: 800}), newMetrics(reg, nil), "5678", "03eb:2011", 0, 16, 0, false, airsensor.Anomaly{}, airsensor.Baseline{})
+	srv.read()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "airsensor_voc_ppm" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["serial"] == "5678" {
+				if labels["device"] != "03eb:2011" {
+					t.Errorf("device label = %q, want 03eb:2011", labels["device"])
+				}
+				return
+			}
+		}
+	}
+	t.Error("no airsensor_voc_ppm series for serial 5678")
+}
+
+func TestManagersDontShareMetrics(t *testing.T) {
+	m1, srv1 := newTestManager(t, airsensor.MockResult{VOC: 800})
+	m2, srv2 := newTestManager(t, airsensor.MockResult{VOC: 900})
+	srv1.read()
+	srv2.read()
+	m1.remove("1234")
+
+	gauge := func(reg *prometheus.Registry) []float64 {
+		t.Helper()
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vs []float64
+		for _, f := range families {
+			if f.GetName() == "airsensor_voc_ppm" {
+				for _, m := range f.GetMetric() {
+					vs = append(vs, m.GetGauge().GetValue())
+				}
+			}
+		}
+		return vs
+	}
+	if vs := gauge(m1.reg); len(vs) != 0 {
+		t.Errorf("first registry after removing its stick: airsensor_voc_ppm %v, want none", vs)
+	}
+	if vs := gauge(m2.reg); fmt.Sprint(vs) != "[900]" {
+		t.Errorf("second registry: airsensor_voc_ppm %v, want [900]", vs)
+	}
+}
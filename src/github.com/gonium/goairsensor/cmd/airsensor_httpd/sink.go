@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// A Sink receives every reading taken by the poll loop. Sinks are shared
+// by the poll loops of all sticks and written to concurrently, so they
+// must be safe for concurrent use.
+type Sink interface {
+	// Write hands r to the sink. It should give up once ctx is done.
+	Write(ctx context.Context, r *airsensor.Reading) error
+	// Close flushes pending readings and releases the sink.
+	Close() error
+}
+
+// sinkFunc turns a function into a Sink that needs no cleanup.
+type sinkFunc func(ctx context.Context, r *airsensor.Reading) error
+
+func (f sinkFunc) Write(ctx context.Context, r *airsensor.Reading) error {
+	return f(ctx, r)
+}
+
+func (f sinkFunc) Close() error {
+	return nil
+}
+
+// namedSink names a sink in log messages.
+type namedSink struct {
+	name string
+	Sink
+}
+
+// writeAll writes r to all sinks concurrently and waits for them. A
+// failing sink is logged and doesn't keep r from the others.
+func writeAll(ctx context.Context, sinks []namedSink, r *airsensor.Reading) {
+	var wg sync.WaitGroup
+	for _, s := range sinks {
+		wg.Add(1)
+		go func(s namedSink) {
+			defer wg.Done()
+			if err := s.Write(ctx, r); err != nil {
+				slog.Error("Writing reading failed", "sink", s.name, "serial", r.Serial, "err", err)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+// drainer is a sink holding readings it has not delivered yet, like a
+// bufferedSink.
+type drainer interface {
+	// drain stops background delivery and delivers the held readings
+	// until all are out or ctx is done. It returns how many were
+	// delivered and how many are left, to be dropped by Close.
+	drain(ctx context.Context) (flushed, left int)
+}
+
+// drainAll drains all sinks concurrently within timeout, logging how many
+// readings each delivered and how many it will drop.
+func drainAll(sinks []namedSink, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, s := range sinks {
+		d, ok := s.Sink.(drainer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, d drainer) {
+			defer wg.Done()
+			flushed, left := d.drain(ctx)
+			switch {
+			case left > 0:
+				slog.Warn("Could not drain sink", "sink", name, "flushed", flushed, "dropped", left)
+			case flushed > 0:
+				slog.Info("Drained sink", "sink", name, "flushed", flushed)
+			}
+		}(s.name, d)
+	}
+	wg.Wait()
+}
+
+// closeAll closes all sinks, logging failures.
+func closeAll(sinks []namedSink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			slog.Error("Closing sink failed", "sink", s.name, "err", err)
+		}
+	}
+}
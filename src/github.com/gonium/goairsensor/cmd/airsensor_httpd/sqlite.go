@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gonium/goairsensor/airsensor"
+	"log/slog"
+	_ "modernc.org/sqlite"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS readings (
+	ts     INTEGER NOT NULL, -- Unix time in nanoseconds
+	device TEXT    NOT NULL, -- serial of the stick
+	voc    INTEGER NOT NULL,
+	valid  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS readings_ts ON readings (ts);
+`
+
+// historyLimit caps the number of rows returned by a single /history
+// query.
+const historyLimit = 10000
+
+// Readings older than the raw retention are averaged per compactBucket,
+// every compactInterval.
+const (
+	compactBucket   = 5 * time.Minute
+	compactInterval = 10 * time.Minute
+)
+
+// sqliteStore records every reading in an SQLite database and answers
+// /history queries from it.
+type sqliteStore struct {
+	db *sql.DB
+
+	// stop ends the compaction loop, if there is one.
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// openSQLiteStore opens the database at path, creating it and its schema
+// if needed. With a positive rawRetention, older readings are compacted
+// periodically, see compact.
+func openSQLiteStore(path string, rawRetention time.Duration) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection avoids busy errors
+	// between the poll loops.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema in %s: %w", path, err)
+	}
+	st := &sqliteStore{db: db, stop: make(chan struct{})}
+	if rawRetention > 0 {
+		st.wg.Add(1)
+		go st.compactLoop(rawRetention)
+	}
+	return st, nil
+}
+
+// compactLoop compacts the readings older than rawRetention right away and
+// then every compactInterval until the store is closed.
+func (st *sqliteStore) compactLoop(rawRetention time.Duration) {
+	defer st.wg.Done()
+	t := time.NewTicker(compactInterval)
+	defer t.Stop()
+	for {
+		n, err := st.compact(context.Background(), time.Now().Add(-rawRetention), compactBucket)
+		if err != nil {
+			slog.Error("Compacting SQLite failed", "err", err)
+		} else if n > 0 {
+			slog.Debug("Compacted SQLite", "buckets", n)
+		}
+		select {
+		case <-st.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// compact replaces the readings of each device taken before cutoff by one
+// per bucket, holding the average of the valid ones, and returns the
+// number of buckets compacted. A bucket without valid readings keeps an
+// invalid one. Only buckets ending before cutoff and holding more than
+// one row are touched, so compacting again changes nothing.
+func (st *sqliteStore) compact(ctx context.Context, cutoff time.Time, bucket time.Duration) (int64, error) {
+	b := int64(bucket)
+	end := cutoff.UnixNano() / b * b
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmts := []struct {
+		q    string
+		args []interface{}
+	}{
+		{`CREATE TEMP TABLE compacted AS
+			SELECT ts / ? AS b, device,
+				COALESCE(CAST(ROUND(AVG(CASE WHEN valid THEN voc END)) AS INTEGER), MIN(voc)) AS voc,
+				MAX(valid) AS valid
+			FROM readings WHERE ts < ? GROUP BY b, device HAVING COUNT(*) > 1`, []interface{}{b, end}},
+		{`DELETE FROM readings WHERE ts < ? AND (ts / ?, device) IN (SELECT b, device FROM compacted)`, []interface{}{end, b}},
+		{`INSERT INTO readings (ts, device, voc, valid) SELECT b * ?, device, voc, valid FROM compacted`, []interface{}{b}},
+	}
+	var n int64
+	for i, s := range stmts {
+		res, err := tx.ExecContext(ctx, s.q, s.args...)
+		if err != nil {
+			return 0, err
+		}
+		if i == len(stmts)-1 {
+			n, _ = res.RowsAffected()
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DROP TABLE compacted"); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (st *sqliteStore) Write(ctx context.Context, r *airsensor.Reading) error {
+	_, err := st.db.ExecContext(ctx, "INSERT INTO readings (ts, device, voc, valid) VALUES (?, ?, ?, ?)",
+		r.Timestamp.UnixNano(), r.Serial, r.VOC, r.Valid)
+	return err
+}
+
+func (st *sqliteStore) Close() error {
+	close(st.stop)
+	st.wg.Wait()
+	return st.db.Close()
+}
+
+// series returns the average of the valid readings of device per bucket
+// between from and to, oldest first.
+func (st *sqliteStore) series(ctx context.Context, device string, from, to time.Time, bucket time.Duration) ([]point, error) {
+	if bucket <= 0 {
+		bucket = 1
+	}
+	rows, err := st.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT ts / ? AS b, AVG(voc) FROM readings WHERE device = ? AND valid AND ts >= ? AND ts <= ? GROUP BY b ORDER BY b LIMIT %d", historyLimit),
+		int64(bucket), device, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []point
+	for rows.Next() {
+		var (
+			b int64
+			p point
+		)
+		if err := rows.Scan(&b, &p.VOC); err != nil {
+			return nil, err
+		}
+		p.Timestamp = time.Unix(0, b*int64(bucket))
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+type historyRow struct {
+	Timestamp jsonTime `json:"ts"`
+	Device    string   `json:"device"`
+	VOC       int16    `json:"voc_ppm"`
+	Valid     bool     `json:"valid"`
+}
+
+// parseTime parses the RFC 3339 time in query parameter name, returning
+// def if it is missing.
+func parseTime(r *http.Request, name string, def time.Time) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q, want RFC 3339", name, v)
+	}
+	return t, nil
+}
+
+// handleHistory returns the readings between ?from= and ?to=, by default
+// those of the last 24 hours, oldest first. ?device=<serial> restricts
+// them to one stick.
+func (st *sqliteStore) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	to, err := parseTime(r, "to", time.Now())
+	if err != nil {
+		writeInvalidParameter(w, "to", err.Error())
+		return
+	}
+	from, err := parseTime(r, "from", to.Add(-24*time.Hour))
+	if err != nil {
+		writeInvalidParameter(w, "from", err.Error())
+		return
+	}
+
+	q := "SELECT ts, device, voc, valid FROM readings WHERE ts >= ? AND ts <= ?"
+	args := []interface{}{from.UnixNano(), to.UnixNano()}
+	if device := r.URL.Query().Get("device"); device != "" {
+		q += " AND device = ?"
+		args = append(args, device)
+	}
+	q += fmt.Sprintf(" ORDER BY ts LIMIT %d", historyLimit)
+	rows, err := st.db.QueryContext(r.Context(), q, args...)
+	if err != nil {
+		slog.Error("Querying SQLite failed", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "query failed", nil)
+		return
+	}
+	defer rows.Close()
+
+	history := []historyRow{}
+	for rows.Next() {
+		var (
+			row historyRow
+			ts  int64
+		)
+		if err := rows.Scan(&ts, &row.Device, &row.VOC, &row.Valid); err != nil {
+			slog.Error("Querying SQLite failed", "err", err)
+			writeError(w, http.StatusInternalServerError, codeInternal, "query failed", nil)
+			return
+		}
+		row.Timestamp = jsonTime(time.Unix(0, ts).UTC())
+		history = append(history, row)
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("Querying SQLite failed", "err", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "query failed", nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"github.com/gonium/goairsensor/airsensor"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type storedRow struct {
+	ts     time.Duration // since the start of the test data
+	device string
+	voc    int16
+	valid  bool
+}
+
+// rows returns all rows of st by device and time.
+func rows(t *testing.T, st *sqliteStore, start time.Time) []storedRow {
+	t.Helper()
+	res, err := st.db.Query("SELECT ts, device, voc, valid FROM readings ORDER BY device, ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	var rs []storedRow
+	for res.Next() {
+		var (
+			r  storedRow
+			ts int64
+		)
+		if err := res.Scan(&ts, &r.device, &r.voc, &r.valid); err != nil {
+			t.Fatal(err)
+		}
+		r.ts = time.Unix(0, ts).Sub(start)
+		rs = append(rs, r)
+	}
+	if err := res.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestSQLiteCompact(t *testing.T) {
+	st, err := openSQLiteStore(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	ctx := context.Background()
+	// start lies on a bucket boundary.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const b = compactBucket
+	for _, r := range []storedRow{
+		// Averaged over the valid readings; the invalid one is dropped.
+		{0, "a", 600, true}, {time.Minute, "a", 700, true}, {2 * time.Minute, "a", 0, false},
+		// Rounded.
+		{time.Minute, "b", 601, true}, {2 * time.Minute, "b", 602, true},
+		// A single reading is left as it is.
+		{b + time.Minute, "a", 800, true},
+		// Without a valid reading, an invalid one is kept.
+		{2*b + time.Minute, "a", 3000, false}, {2*b + 2*time.Minute, "a", 100, false},
+		// The bucket holding the cutoff keeps its raw readings.
+		{3*b + 30*time.Second, "a", 900, true}, {3*b + time.Minute, "a", 950, true},
+	} {
+		if err := st.Write(ctx, &airsensor.Reading{Timestamp: start.Add(r.ts), Serial: r.device, VOC: r.voc, Valid: r.valid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []storedRow{
+		{0, "a", 650, true},
+		{b + time.Minute, "a", 800, true},
+		{2 * b, "a", 100, false},
+		{3*b + 30*time.Second, "a", 900, true},
+		{3*b + time.Minute, "a", 950, true},
+		{0, "b", 602, true},
+	}
+	cutoff := start.Add(3*b + 2*time.Minute)
+
+	n, err := st.compact(ctx, cutoff, b)
+	if err != nil {
+		t.Fatalf("compact() error = %v", err)
+	}
+	if n != 3 {
+		t.Errorf("compact() compacted %d buckets, want 3", n)
+	}
+	if got := rows(t, st, start); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows after compact() = %v, want %v", got, want)
+	}
+
+	n, err = st.compact(ctx, cutoff, b)
+	if err != nil {
+		t.Fatalf("second compact() error = %v", err)
+	}
+	if n != 0 {
+		t.Errorf("second compact() compacted %d buckets, want 0", n)
+	}
+	if got := rows(t, st, start); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows after the second compact() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gonium/goairsensor/airsensor"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sensorState is what is kept of a stick across restarts.
+type sensorState struct {
+	Serial string `json:"serial"`
+	// LastValid is the last valid reading taken.
+	LastValid *airsensor.Reading `json:"last_valid,omitempty"`
+	// Smoothed is the moving average, if there is one.
+	Smoothed *float64 `json:"smoothed,omitempty"`
+	// Baseline is the clean-air baseline, if there is one.
+	Baseline *baselineState `json:"baseline,omitempty"`
+}
+
+// baselineState is a saved airsensor.Baseline.
+type baselineState struct {
+	Value float64   `json:"value"`
+	At    time.Time `json:"at"`
+}
+
+// state returns what to keep of the stick across restarts. A restored
+// reading is kept until a fresh one replaces it.
+func (srv *server) state() sensorState {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	st := sensorState{Serial: srv.serial, LastValid: srv.lastValid}
+	if st.LastValid == nil {
+		st.LastValid = srv.restored
+	}
+	if v, ok := srv.ema.Value(); ok {
+		st.Smoothed = &v
+	}
+	if v, at, ok := srv.baseline.Value(); ok {
+		st.Baseline = &baselineState{Value: v, At: at}
+	}
+	return st
+}
+
+// restore resumes from a saved state. The reading is served as stale
+// until the first successful read.
+func (srv *server) restore(st sensorState) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.restored = st.LastValid
+	if st.Smoothed != nil {
+		srv.ema.Set(*st.Smoothed)
+	}
+	if st.Baseline != nil {
+		srv.baseline.Set(st.Baseline.Value, st.Baseline.At)
+	}
+}
+
+// saveState writes the state of all sticks to path. The file is replaced
+// atomically, so a crash while writing leaves the previous one.
+func (m *SensorManager) saveState(path string) error {
+	var states []sensorState
+	for _, srv := range m.all() {
+		states = append(states, srv.state())
+	}
+	b, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadState restores the sticks saved in path. A missing file is not an
+// error; sticks not connected any more are ignored.
+func (m *SensorManager) loadState(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var states []sensorState
+	if err := json.Unmarshal(b, &states); err != nil {
+		return err
+	}
+	for _, st := range states {
+		if srv, ok := m.get(st.Serial); ok && st.Serial != "" {
+			srv.restore(st)
+			slog.Info("Restored last reading", "serial", st.Serial)
+		}
+	}
+	return nil
+}
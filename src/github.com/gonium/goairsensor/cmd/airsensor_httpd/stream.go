@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gonium/goairsensor/airsensor"
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamBuffer is the number of readings queued per client. A client
+// falling further behind is dropped.
+const streamBuffer = 8
+
+const streamWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{}
+
+// hub fans out the readings of the poll loop to all WebSocket clients.
+// Publishing never blocks the poller.
+type hub struct {
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+func newHub() *hub {
+	return &hub{clients: make(map[chan []byte]struct{})}
+}
+
+func (h *hub) subscribe() chan []byte {
+	ch := make(chan []byte, streamBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clients[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes ch and closes it, unless it was already dropped.
+func (h *hub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[ch]; ok {
+		delete(h.clients, ch)
+		close(ch)
+	}
+}
+
+// Write queues r for every client and drops those whose queue is full.
+func (h *hub) Write(_ context.Context, r *airsensor.Reading) error {
+	msg, err := json.Marshal(newVOCResponse(r))
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- msg:
+		default:
+			slog.Warn("Dropping slow stream client")
+			delete(h.clients, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+func (h *hub) Close() error {
+	return nil
+}
+
+// handleStream upgrades the connection to a WebSocket and pushes every
+// new reading as JSON.
+func (h *hub) handleStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied with an error.
+		slog.Warn("WebSocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+
+	// Clients don't send anything; reading detects when they go away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"),
+					time.Now().Add(time.Second))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
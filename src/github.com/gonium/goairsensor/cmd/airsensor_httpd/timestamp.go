@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// timestampFormat selects how timestamps are rendered in CSV, JSON and
+// log output. InfluxDB line protocol always uses nanoseconds.
+type timestampFormat string
+
+const (
+	formatRFC3339  timestampFormat = "rfc3339"
+	formatUnix     timestampFormat = "unix"
+	formatUnixNano timestampFormat = "unixnano"
+)
+
+// tsFormat is the format set with -timestamp-format.
+var tsFormat = formatRFC3339
+
+func parseTimestampFormat(s string) (timestampFormat, error) {
+	switch f := timestampFormat(s); f {
+	case formatRFC3339, formatUnix, formatUnixNano:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid timestamp format %q, must be rfc3339, unix or unixnano", s)
+}
+
+// formatTime renders t in tsFormat, using layout for rfc3339.
+func formatTime(t time.Time, layout string) string {
+	switch tsFormat {
+	case formatUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case formatUnixNano:
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return t.Format(layout)
+}
+
+// jsonTime is a time marshalled in tsFormat: an RFC 3339 string, or a
+// number of seconds or nanoseconds since the Unix epoch.
+type jsonTime time.Time
+
+func (t jsonTime) MarshalJSON() ([]byte, error) {
+	if tsFormat == formatRFC3339 {
+		return json.Marshal(time.Time(t))
+	}
+	return []byte(formatTime(time.Time(t), "")), nil
+}
+
+// jsonTimePtr converts t for fields omitted when unset.
+func jsonTimePtr(t *time.Time) *jsonTime {
+	if t == nil {
+		return nil
+	}
+	jt := jsonTime(*t)
+	return &jt
+}
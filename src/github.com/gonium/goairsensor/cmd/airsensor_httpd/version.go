@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// Build information, injected at link time:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+type versionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+func buildInfo() versionResponse {
+	return versionResponse{Version: version, Commit: commit, Date: date, GoVersion: runtime.Version()}
+}
+
+// printVersion prints the build information for -version.
+func printVersion() {
+	b := buildInfo()
+	fmt.Printf("airsensor_httpd %s (commit %s, built %s, %s)\n", b.Version, b.Commit, b.Date, b.GoVersion)
+}
+
+// handleVersion serves the build information.
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, buildInfo())
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ways of combining the readings of a virtual sensor.
+const (
+	combineMean   = "mean"
+	combineMedian = "median"
+)
+
+// virtualSensor combines the readings of several sticks, e.g. two in one
+// room for redundancy.
+type virtualSensor struct {
+	// Method is mean or median, mean by default.
+	Method string `yaml:"method"`
+	// Devices are the serials or names (see nameMap) of the sticks.
+	Devices []string `yaml:"devices"`
+}
+
+// virtualMap holds the virtual sensors by name. As a flag it is a
+// comma-separated list of name=[method:]device+device entries, e.g.
+// room=median:1234+5678; in the YAML file it is a mapping.
+type virtualMap map[string]virtualSensor
+
+func (m virtualMap) String() string {
+	entries := make([]string, 0, len(m))
+	for name, vs := range m {
+		method := ""
+		if vs.Method != "" {
+			method = vs.Method + ":"
+		}
+		entries = append(entries, name+"="+method+strings.Join(vs.Devices, "+"))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// Set replaces the map with the entries in s.
+func (m *virtualMap) Set(s string) error {
+	virtual := make(virtualMap)
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, devices, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || devices == "" {
+			return fmt.Errorf("invalid virtual sensor %q, must be name=[method:]device+device", entry)
+		}
+		var vs virtualSensor
+		if method, rest, ok := strings.Cut(devices, ":"); ok {
+			vs.Method, devices = method, rest
+		}
+		vs.Devices = strings.Split(devices, "+")
+		virtual[name] = vs
+	}
+	*m = virtual
+	return nil
+}
+
+// virtualServer serves a virtual sensor from the servers of its sticks.
+// They are looked up on every reading, so sticks replugged with hotplug
+// are picked up again.
+type virtualServer struct {
+	m       *SensorManager
+	name    string
+	method  string
+	devices []string
+}
+
+// addVirtual registers the virtual sensor vs under name. Its devices have
+// to be opened sticks, given by serial or name.
+func (m *SensorManager) addVirtual(name string, vs virtualSensor) error {
+	if m.has(name) {
+		return fmt.Errorf("virtual sensor %q has the serial of a stick", name)
+	}
+	if _, ok := m.virtual[name]; ok {
+		return fmt.Errorf("duplicate virtual sensor %q", name)
+	}
+	v := &virtualServer{m: m, name: name, method: vs.Method, devices: vs.Devices}
+	switch v.method {
+	case "":
+		v.method = combineMean
+	case combineMean, combineMedian:
+	default:
+		return fmt.Errorf("invalid method %q of virtual sensor %q, must be mean or median", vs.Method, name)
+	}
+	if len(vs.Devices) == 0 {
+		return fmt.Errorf("virtual sensor %q has no devices", name)
+	}
+	for _, dev := range vs.Devices {
+		if _, ok := m.byDevice(dev); !ok {
+			return fmt.Errorf("virtual sensor %q: no sensor %q", name, dev)
+		}
+	}
+	m.virtual[name] = v
+	return nil
+}
+
+// byDevice returns the server of the stick with the given serial or name.
+func (m *SensorManager) byDevice(dev string) (*server, bool) {
+	if srv, ok := m.get(dev); ok && dev != "" {
+		return srv, true
+	}
+	for _, srv := range m.all() {
+		if cfg.Names.name(srv.serial) == dev {
+			return srv, true
+		}
+	}
+	return nil, false
+}
+
+// combine returns the mean or median of vs; vs must not be empty.
+func combine(method string, vs []float64) float64 {
+	if method == combineMedian {
+		vs = append([]float64(nil), vs...)
+		sort.Float64s(vs)
+		n := len(vs)
+		if n%2 == 1 {
+			return vs[n/2]
+		}
+		return (vs[n/2-1] + vs[n/2]) / 2
+	}
+	var sum float64
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs))
+}
+
+type virtualResponse struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	// VOC and Smoothed combine the calibrated and smoothed values of
+	// the sticks.
+	VOC       float64  `json:"voc_ppm"`
+	Smoothed  float64  `json:"voc_smoothed_ppm"`
+	Unit      string   `json:"unit"`
+	Timestamp jsonTime `json:"ts"`
+	// Count is the number of sticks combined, out of Devices; failed
+	// and stabilizing sticks are left out.
+	Count   int      `json:"count"`
+	Devices int      `json:"devices"`
+	Serials []string `json:"serials"`
+}
+
+// reading combines the latest valid readings of the sticks, as returned
+// by get. Unplugged sticks are left out. ok is false if no stick has one.
+func (v *virtualServer) reading(get func(*server) *lastRead) (resp virtualResponse, ok bool) {
+	resp = virtualResponse{Name: v.name, Method: v.method, Unit: vocUnit, Devices: len(v.devices), Serials: []string{}}
+	var calibrated, smoothed []float64
+	var latest time.Time
+	for _, dev := range v.devices {
+		srv, ok := v.m.byDevice(dev)
+		if !ok {
+			continue
+		}
+		last := get(srv)
+		if last == nil || last.err != nil || last.r == nil || !last.r.Valid || last.r.Stabilizing {
+			continue
+		}
+		calibrated = append(calibrated, last.r.Calibrated)
+		smoothed = append(smoothed, last.r.Smoothed)
+		resp.Serials = append(resp.Serials, srv.serial)
+		if last.r.Timestamp.After(latest) {
+			latest = last.r.Timestamp
+		}
+	}
+	resp.Count = len(calibrated)
+	if resp.Count == 0 {
+		return resp, false
+	}
+	resp.VOC = combine(v.method, calibrated)
+	resp.Smoothed = combine(v.method, smoothed)
+	resp.Timestamp = jsonTime(latest)
+	return resp, true
+}
+
+// handleVOC reads the sticks of the virtual sensor, each at most once per
+// -min-read-interval, and combines their valid readings.
+func (v *virtualServer) handleVOC(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	resp, ok := v.reading(func(srv *server) *lastRead {
+		r, _, err := srv.readCached()
+		if err != nil {
+			logReadError(srv.serial, err)
+		}
+		return &lastRead{r: r, err: err}
+	})
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, codeNoValidReading,
+			fmt.Sprintf("no valid reading of any device of virtual sensor %q", v.name), map[string]interface{}{"name": v.name})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+var (
+	virtualVOCDesc = prometheus.NewDesc("airsensor_virtual_voc_ppm",
+		"Mean or median of the last valid readings of the sticks of a virtual sensor.",
+		[]string{"name", "method"}, nil)
+	virtualDevicesDesc = prometheus.NewDesc("airsensor_virtual_devices",
+		"Number of sticks with a recent valid reading combined into a virtual sensor.",
+		[]string{"name"}, nil)
+)
+
+// exportVirtual registers the metrics of the virtual sensors, if there
+// are any, see virtualCollector.
+func (m *SensorManager) exportVirtual(staleAfter time.Duration) {
+	if len(m.virtual) > 0 {
+		register[prometheus.Collector](m.reg, virtualCollector{m: m, staleAfter: staleAfter})
+	}
+}
+
+// virtualCollector exports the virtual sensors from the last reads of
+// their sticks, without reading them. Readings older than staleAfter, if
+// positive, are left out.
+type virtualCollector struct {
+	m          *SensorManager
+	staleAfter time.Duration
+}
+
+func (c virtualCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- virtualVOCDesc
+	ch <- virtualDevicesDesc
+}
+
+func (c virtualCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, v := range c.m.virtual {
+		resp, ok := v.reading(func(srv *server) *lastRead {
+			srv.mu.Lock()
+			last := srv.last
+			srv.mu.Unlock()
+			if c.staleAfter > 0 && time.Since(last.at) > c.staleAfter {
+				return nil
+			}
+			return &last
+		})
+		ch <- prometheus.MustNewConstMetric(virtualDevicesDesc, prometheus.GaugeValue, float64(resp.Count), v.name)
+		if ok {
+			ch <- prometheus.MustNewConstMetric(virtualVOCDesc, prometheus.GaugeValue, resp.VOC, v.name, v.method)
+		}
+	}
+}